@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+)
+
+// manifestFileName is the name of the file with checksums of all the files in the archive,
+// in the format of sha256sum utility so it could be verified with `sha256sum -c manifest.sha256`
+const manifestFileName = "manifest.sha256"
+
+// evidenceArchive stores messages and profiles of the users before destructive actions are taken on them
+type evidenceArchive struct {
+	dir        string
+	withMedia  bool
+	downloader *downloader.Downloader
+}
+
+// archivedProfile is the user profile at the moment of archiving
+type archivedProfile struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username,omitempty"`
+	FirstName string    `json:"first_name,omitempty"`
+	LastName  string    `json:"last_name,omitempty"`
+	About     string    `json:"about,omitempty"`
+	Bot       bool      `json:"bot,omitempty"`
+	Scam      bool      `json:"scam,omitempty"`
+	Fake      bool      `json:"fake,omitempty"`
+	Archived  time.Time `json:"archived"`
+	Raw       string    `json:"raw"`
+}
+
+// archivedMessage is the single message of the user
+type archivedMessage struct {
+	ID       int              `json:"id"`
+	Date     time.Time        `json:"date"`
+	Edited   *time.Time       `json:"edited,omitempty"`
+	Text     string           `json:"text,omitempty"`
+	ReplyTo  int              `json:"reply_to,omitempty"`
+	Forward  string           `json:"forward,omitempty"`
+	Entities []archivedEntity `json:"entities,omitempty"`
+	Media    *archivedMedia   `json:"media,omitempty"`
+	Raw      string           `json:"raw"`
+}
+
+// archivedEntity is the formatting entity of the message: link, mention, etc.
+type archivedEntity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"`
+}
+
+// archivedMedia is the metadata of the message media, with the path to the file if it was downloaded
+type archivedMedia struct {
+	Type     string `json:"type"`
+	ID       int64  `json:"id,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	File     string `json:"file,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	location tg.InputFileLocationClass
+}
+
+// newEvidenceArchive creates archive directory for the current run in given base directory
+func newEvidenceArchive(baseDir string, withMedia bool) (*evidenceArchive, error) {
+	dir := filepath.Join(baseDir, time.Now().Format("2006-01-02T15-04-05"))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating archive directory %s: %w", dir, err)
+	}
+	return &evidenceArchive{dir: dir, withMedia: withMedia, downloader: downloader.NewDownloader()}, nil
+}
+
+// archiveUser stores the profile of the user and their messages in the scope in the user's directory of the archive
+func (a *evidenceArchive) archiveUser(ctx context.Context, api *tg.Client, channel *tg.Channel, user *tg.InputPeerUser, scope deleteScope) (int, error) {
+	userDir := filepath.Join(a.dir, strconv.FormatInt(user.UserID, 10))
+	if err := os.MkdirAll(userDir, 0o700); err != nil {
+		return 0, fmt.Errorf("error creating archive directory %s: %w", userDir, err)
+	}
+
+	profile, err := getUserProfile(ctx, api, user)
+	if err != nil {
+		return 0, err
+	}
+	if err = writeJSON(filepath.Join(userDir, "profile.json"), profile); err != nil {
+		return 0, err
+	}
+
+	messages, err := getUserMessages(ctx, api, channel, user, scope)
+	if err != nil {
+		return 0, err
+	}
+	archived := make([]archivedMessage, 0, len(messages))
+	for _, m := range messages {
+		am := newArchivedMessage(m)
+		if am.Media != nil && am.Media.location != nil && a.withMedia {
+			a.downloadMedia(ctx, api, userDir, am)
+		}
+		archived = append(archived, am)
+	}
+	if err = writeJSON(filepath.Join(userDir, "messages.json"), archived); err != nil {
+		return 0, err
+	}
+	return len(archived), nil
+}
+
+// downloadMedia downloads the media file of the message to the user directory, errors are stored in the media info
+func (a *evidenceArchive) downloadMedia(ctx context.Context, api *tg.Client, userDir string, m archivedMessage) {
+	fileName := fmt.Sprintf("%d-%d", m.ID, m.Media.ID)
+	if m.Media.FileName != "" {
+		fileName += "-" + filepath.Base(m.Media.FileName)
+	}
+	if _, err := a.downloader.Download(api, m.Media.location).ToPath(ctx, filepath.Join(userDir, fileName)); err != nil {
+		log.Printf("[WARN] error downloading media of message %d: %v", m.ID, err)
+		m.Media.Error = err.Error()
+		return
+	}
+	m.Media.File = fileName
+}
+
+// writeManifest writes checksums of all the files in the archive and returns the checksum of the manifest itself,
+// which could be recorded elsewhere to prove the archive wasn't modified
+func (a *evidenceArchive) writeManifest() (string, error) {
+	var lines []string
+	err := filepath.Walk(a.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() == manifestFileName {
+			return nil
+		}
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(a.dir, path)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s", sum, filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error calculating archive checksums: %w", err)
+	}
+	sort.Strings(lines)
+	manifest := strings.Join(lines, "\n") + "\n"
+	if err = os.WriteFile(filepath.Join(a.dir, manifestFileName), []byte(manifest), 0o600); err != nil {
+		return "", fmt.Errorf("error writing archive manifest: %w", err)
+	}
+	sum := sha256.Sum256([]byte(manifest))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// getUserProfile retrieves full information about the user
+func getUserProfile(ctx context.Context, api *tg.Client, user *tg.InputPeerUser) (archivedProfile, error) {
+	full, err := api.UsersGetFullUser(ctx, &tg.InputUser{UserID: user.UserID, AccessHash: user.AccessHash})
+	if err != nil {
+		return archivedProfile{}, fmt.Errorf("error retrieving user %d profile: %w", user.UserID, err)
+	}
+	profile := archivedProfile{ID: user.UserID, About: full.FullUser.About, Archived: time.Now(), Raw: full.FullUser.String()}
+	for _, u := range full.Users {
+		if u, ok := u.(*tg.User); ok && u.ID == user.UserID {
+			profile.Username = u.Username
+			profile.FirstName = u.FirstName
+			profile.LastName = u.LastName
+			profile.Bot = u.Bot
+			profile.Scam = u.Scam
+			profile.Fake = u.Fake
+			profile.Raw = u.String() + "\n" + profile.Raw
+		}
+	}
+	return profile, nil
+}
+
+// getUserMessages returns all messages of the user in the scope, going through all the search result pages
+func getUserMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass, scope deleteScope) ([]tg.MessageClass, error) {
+	var result []tg.MessageClass
+	err := forEachUserMessage(ctx, api, channel, user, scope, func(m tg.MessageClass) {
+		result = append(result, m)
+	})
+	return result, err
+}
+
+// newArchivedMessage converts Telegram message to the archived one
+func newArchivedMessage(m tg.MessageClass) archivedMessage {
+	res := archivedMessage{ID: m.GetID(), Raw: m.String()}
+	switch v := m.(type) {
+	case *tg.Message:
+		res.Date = time.Unix(int64(v.Date), 0)
+		if editDate, ok := v.GetEditDate(); ok {
+			edited := time.Unix(int64(editDate), 0)
+			res.Edited = &edited
+		}
+		res.Text = v.Message
+		if replyTo, ok := v.GetReplyTo(); ok {
+			if header, ok := replyTo.(*tg.MessageReplyHeader); ok {
+				res.ReplyTo = header.ReplyToMsgID
+			}
+		}
+		if fwd, ok := v.GetFwdFrom(); ok {
+			res.Forward = fwd.String()
+		}
+		for _, e := range v.Entities {
+			entity := archivedEntity{Type: e.TypeName(), Offset: e.GetOffset(), Length: e.GetLength()}
+			if u, ok := e.(*tg.MessageEntityTextURL); ok {
+				entity.URL = u.URL
+			}
+			res.Entities = append(res.Entities, entity)
+		}
+		if media, ok := v.GetMedia(); ok {
+			res.Media = newArchivedMedia(media)
+		}
+	case *tg.MessageService:
+		res.Date = time.Unix(int64(v.Date), 0)
+		res.Text = "[system] " + v.Action.TypeName()
+	}
+	return res
+}
+
+// newArchivedMedia returns metadata of the message media, along with the location to download the file
+func newArchivedMedia(media tg.MessageMediaClass) *archivedMedia {
+	res := &archivedMedia{Type: media.TypeName()}
+	switch v := media.(type) {
+	case *tg.MessageMediaPhoto:
+		if photo, ok := v.Photo.(*tg.Photo); ok && len(photo.Sizes) > 0 {
+			res.ID = photo.ID
+			res.MimeType = "image/jpeg"
+			// the last size is the biggest one
+			res.location = &tg.InputPhotoFileLocation{
+				ID:            photo.ID,
+				AccessHash:    photo.AccessHash,
+				FileReference: photo.FileReference,
+				ThumbSize:     photo.Sizes[len(photo.Sizes)-1].GetType(),
+			}
+		}
+	case *tg.MessageMediaDocument:
+		if doc, ok := v.Document.(*tg.Document); ok {
+			res.ID = doc.ID
+			res.MimeType = doc.MimeType
+			res.Size = doc.Size
+			for _, attr := range doc.Attributes {
+				if name, ok := attr.(*tg.DocumentAttributeFilename); ok {
+					res.FileName = name.FileName
+				}
+			}
+			res.location = doc.AsInputDocumentFileLocation()
+		}
+	}
+	return res
+}
+
+// writeJSON writes indented JSON representation of the value to the file
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %w", path, err)
+	}
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// fileChecksum returns hex-encoded sha256 of the file content
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
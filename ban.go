@@ -2,139 +2,397 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/go-pkgz/lgr"
 	"github.com/gotd/td/tg"
 )
 
+type banParams struct {
+	filePath              string
+	ignoreChannelMismatch bool
+	acceptRejected        bool
+	retryFailed           bool
+	action                banAction
+	restrictFor           time.Duration // restriction duration for restrict action, 0 is forever
+	scope                 deleteScope
+	archiveDir            string // base directory for the evidence archive, empty disables archiving
+	archiveMedia          bool
+	reportSpam            bool          // report messages of the users as spam before deleting them
+	reportPeer            bool          // report the users themselves as spammers
+	verify                bool          // verify the state of the processed users after the run
+	maxMembership         time.Duration // users who joined earlier than that long ago are not touched, 0 is no limit
+	limits                safetyLimits
+	dryRun                bool             // only plan the run without making any destructive call
+	yes                   bool             // do not ask for the confirmation before the run
+	archive               *evidenceArchive // archive of the current run, created by banAndKickUsers
+	adminPhone            string           // phone of the logged-in admin, to detect lists produced by another account
+	peerCachePath         string           // file with access hashes valid for the logged-in admin
+	resolver              *peerResolver    // resolver of the list users, created by banAndKickUsers
+}
+
+// banAction is what is done to the user from the ban list after their messages are deleted
+type banAction string
+
+const (
+	banActionBan      banAction = "ban"      // ban and kick from the channel
+	banActionRestrict banAction = "restrict" // leave in the channel, but forbid sending anything
+	banActionNone     banAction = "none"     // only delete the messages
+)
+
+// rights returns banned rights for the action, or false if user should be left alone
+func (a banAction) rights(restrictFor time.Duration) (tg.ChatBannedRights, bool) {
+	switch a {
+	case banActionBan:
+		return tg.ChatBannedRights{
+			ViewMessages: true,
+			SendMessages: true,
+			SendMedia:    true,
+			SendStickers: true,
+			SendGifs:     true,
+			SendGames:    true,
+			SendInline:   true,
+			EmbedLinks:   true,
+			SendPolls:    true,
+			ChangeInfo:   true,
+			InviteUsers:  true,
+			PinMessages:  true,
+			UntilDate:    0, // forever
+		}, true
+	case banActionRestrict:
+		rights := tg.ChatBannedRights{
+			SendMessages: true,
+			SendMedia:    true,
+			SendStickers: true,
+			SendGifs:     true,
+			SendGames:    true,
+			SendInline:   true,
+			EmbedLinks:   true,
+			SendPolls:    true,
+			ChangeInfo:   true,
+			InviteUsers:  true,
+			PinMessages:  true,
+		}
+		if restrictFor > 0 {
+			rights.UntilDate = int(time.Now().Add(restrictFor).Unix())
+		}
+		return rights, true
+	}
+	return tg.ChatBannedRights{}, false
+}
+
 // bans users from given file, cleans up their messages and kicks them afterwards.
-// in case of errors during the run, writes unprocessed errors back to the same file.
-func banAndKickUsers(ctx context.Context, api *tg.Client, channel *tg.Channel, filePath string) {
-	users, err := readUserIDsFromCSV(filePath)
+// the result for every user is recorded in the status sidecar next to the file, which is used to resume the run,
+// and the file itself is never modified.
+func banAndKickUsers(ctx context.Context, api *tg.Client, channel *tg.Channel, params banParams) {
+	filePath := params.filePath
+	list, err := readBanList(filePath)
 	if err != nil {
 		log.Printf("[ERROR] error reading users from the file %s: %v", filePath, err)
 		return
 	}
+	meta := list.meta
+	if meta.isZero() {
+		log.Printf("[WARN] Ban list %s has no metadata, can't verify it was created for channel %q", filePath, channel.Title)
+	}
+	if err = meta.checkChannel(channel.ID); err != nil {
+		if !params.ignoreChannelMismatch {
+			log.Printf("[ERROR] %v, refusing to ban, set --ban-ignore-channel-mismatch to override", err)
+			return
+		}
+		log.Printf("[WARN] %v, proceeding as --ban-ignore-channel-mismatch is set", err)
+	}
+	if !meta.isZero() {
+		log.Printf("[INFO] Ban list created %s by revision %s for channel %d (%q)", meta.created, meta.revision, meta.channelID, meta.channelTitle)
+	}
 
-	stoppedIndex := banUserAndClearMessages(ctx, api, channel, users)
-
-	// save unprocessed users to the new file, so that it would be easier to restart the process
-	if stoppedIndex == len(users)-1 {
+	list.logRejected(filePath)
+	if len(list.rejected) > 0 && !params.acceptRejected {
+		log.Printf("[ERROR] Refusing to ban with rejected rows in the list, fix them or set --ban-accept-rejected to skip them")
 		return
 	}
-	if stoppedIndex == 0 {
-		log.Printf("[INFO] Canceled without processing any entries, restart the same command to ban users")
-	}
 
-	usersToBan := make([]banUserInfo, len(users[stoppedIndex:]))
-	for i, user := range users[stoppedIndex:] {
-		usersToBan[i] = banUserInfo{userID: user.UserID, accessHash: user.AccessHash}
+	// access hashes are valid only for the account which fetched them
+	if params.resolver, err = newPeerResolver(api, channel, list.entries, params.peerCachePath); err != nil {
+		log.Printf("[ERROR] %v", err)
+		return
 	}
-	if e := writeUsersToFile(usersToBan, filePath); err != nil {
-		log.Printf("[ERROR] Error writing rest of users to ban after context cancel to file: %v", e)
-	} else {
-		log.Printf("[INFO] Success, rest of users (%d-%d) to ban after context cancel written to the same file %s, restart the same command to ban users",
-			stoppedIndex,
-			len(users),
-			filePath)
+	defer params.resolver.flush()
+	foreign := meta.adminPhoneHash != "" && meta.adminPhoneHash != phoneHash(params.adminPhone)
+	if foreign {
+		log.Printf("[INFO] Ban list %s is produced by another admin account, re-resolving the users", filePath)
 	}
-}
+	params.resolver.prepare(ctx, list.entries, foreign)
 
-// banUserAndClearMessages bans users and clears their messages, and returns number of processed users as result
-func banUserAndClearMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, users []*tg.InputPeerUser) int {
-	for i, user := range users {
-		log.Printf("[DEBUG] Banning and kicking user %d forever", user.UserID)
-		log.Printf("[DEBUG] Deleting messages by the user %d", user.UserID)
-		_, err := api.ChannelsDeleteParticipantHistory(ctx, &tg.ChannelsDeleteParticipantHistoryRequest{
-			Channel:     channel.AsInput(),
-			Participant: user,
-		})
-		if err != nil {
-			log.Printf("[ERROR] error deleting messages by the user %d: %v", user.UserID, err)
-		}
-		_, err = api.ChannelsEditBanned(ctx, &tg.ChannelsEditBannedRequest{
-			Channel:     channel.AsInput(),
-			Participant: user,
-			BannedRights: tg.ChatBannedRights{
-				ViewMessages: true,
-				SendMessages: true,
-				SendMedia:    true,
-				SendStickers: true,
-				SendGifs:     true,
-				SendGames:    true,
-				SendInline:   true,
-				EmbedLinks:   true,
-				SendPolls:    true,
-				ChangeInfo:   true,
-				InviteUsers:  true,
-				PinMessages:  true,
-				UntilDate:    0, // forever
-			},
-		})
-		if err != nil {
-			log.Printf("[ERROR] error banning user %d: %v", user.UserID, err)
+	status, err := openBanStatus(statusFilePath(filePath))
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return
+	}
+	defer func() {
+		if e := status.Close(); e != nil {
+			log.Printf("[ERROR] %v", e)
 		}
-		// do not attempt to ban users after the context is canceled
-		select {
-		case <-ctx.Done():
-			return i
-		default:
+	}()
+
+	// users processed in the previous runs are skipped, except for retryable failures when retry is requested
+	userIDs := make([]int64, len(list.entries))
+	var users []*tg.InputPeerUser
+	var pending []banListEntry
+	for i, entry := range list.entries {
+		userIDs[i] = entry.user.userID
+		statusEntry, ok := status.getEntry(entry.user.userID)
+		if !ok || statusEntry.status == banStatusPending ||
+			(params.retryFailed && statusEntry.status == banStatusFailed && statusEntry.class.retryable()) {
+			users = append(users, params.resolver.inputPeer(entry))
+			pending = append(pending, entry)
 		}
-		log.Printf("[INFO] Done processing #%d/%d", i+1, len(users))
 	}
-	return len(users) - 1
-}
+	if len(users) < len(list.entries) {
+		counts := status.count(userIDs)
+		log.Printf("[INFO] Resuming the run from %s: %d users done, %d failed, %d skipped, %d pending",
+			status.path, counts[banStatusDone], counts[banStatusFailed], counts[banStatusSkipped], counts[banStatusPending])
+	}
+	if len(users) == 0 && params.verify {
+		log.Printf("[INFO] All users are processed already, only verifying the results")
+		verifyBans(ctx, api, channel, list, status, params)
+		return
+	}
+	if len(users) == 0 {
+		log.Printf("[INFO] All users of %s are processed already, nothing left to do", filePath)
+		return
+	}
 
-// readUserIDsFromCSV reads user IDs from the second column of tab-separated CSV file
-func readUserIDsFromCSV(filePath string) ([]*tg.InputPeerUser, error) {
-	f, err := os.Open(filePath)
+	log.Printf("[INFO] Deleting %s of every user, then applying %q action", params.scope, params.action)
+	report := preflightCheck(ctx, api, channel, len(users), params.action)
+	report.log(channel, len(users))
+	if params.dryRun {
+		planBanRun(ctx, api, channel, list, users, params)
+		return
+	}
+	if !report.ok() {
+		log.Printf("[ERROR] Pre-flight check failed, no users were banned")
+		return
+	}
+	breaches, err := params.limits.checkRun(ctx, api, channel, len(users))
 	if err != nil {
-		return nil, fmt.Errorf("error opening %s: %w", filePath, err)
+		log.Printf("[ERROR] Error checking safety limits, no users were banned: %v", err)
+		return
+	}
+	if len(breaches) > 0 {
+		for _, b := range breaches {
+			log.Printf("[ERROR] Safety limit breached: %s", b)
+		}
+		log.Printf("[ERROR] Aborting, no users were banned. Check the ban list, and set --ban-force if it's really intended")
+		return
 	}
 
-	var ids []*tg.InputPeerUser
-	var sawFirstRow bool
-	r := csv.NewReader(f)
-	r.Comma = '\t'
-	for {
-		record, e := r.Read()
-
-		if e == io.EOF {
-			break
+	if !params.yes {
+		printBanSummary(os.Stdout, channel, pending, params)
+		if !confirmBan(ctx, os.Stdin, os.Stdout, channel, len(users)) {
+			log.Printf("[INFO] Not confirmed, no users were banned")
+			return
 		}
-		if e != nil {
-			return nil, fmt.Errorf("error reading %s: %w", filePath, e)
+	}
+
+	if params.archiveDir != "" {
+		if params.archive, err = newEvidenceArchive(params.archiveDir, params.archiveMedia); err != nil {
+			log.Printf("[ERROR] %v", err)
+			return
 		}
+		defer func() {
+			sum, e := params.archive.writeManifest()
+			if e != nil {
+				log.Printf("[ERROR] %v", e)
+				return
+			}
+			log.Printf("[INFO] Evidence archive is written to %s, manifest sha256 is %s", params.archive.dir, sum)
+		}()
+	}
+
+	banUserAndClearMessages(ctx, api, channel, users, status, params)
 
-		if !sawFirstRow {
-			sawFirstRow = true
-			continue
+	counts := status.count(userIDs)
+	log.Printf("[INFO] Ban run finished: %d users done, %d failed, %d skipped, %d pending, status is written to %s",
+		counts[banStatusDone], counts[banStatusFailed], counts[banStatusSkipped], counts[banStatusPending], status.path)
+	classes := status.countClasses(userIDs)
+	for _, class := range failureClasses {
+		if classes[class] > 0 {
+			log.Printf("[INFO] %d users with %s errors", classes[class], class)
 		}
+	}
+	if counts[banStatusPending] > 0 {
+		log.Printf("[INFO] Restart the same command to continue from where the run stopped")
+	}
+	writeRetryFile(list, status, filePath)
+	if params.verify {
+		verifyBans(ctx, api, channel, list, status, params)
+	}
+}
 
-		// user ID should be second column and accessHash is third
-		if len(record) < 3 {
-			continue
+// writeRetryFile writes users which failed with retryable errors to a separate ban list,
+// with the original columns as well as the failure class and the error
+func writeRetryFile(list banList, status *banStatusFile, filePath string) {
+	var entries []banListEntry
+	var failures []banStatusEntry
+	for _, entry := range list.entries {
+		statusEntry, ok := status.getEntry(entry.user.userID)
+		if ok && statusEntry.status == banStatusFailed && statusEntry.class.retryable() {
+			entries = append(entries, entry)
+			failures = append(failures, statusEntry)
 		}
+	}
+	if len(entries) == 0 {
+		return
+	}
+	retryFilePath := strings.TrimSuffix(filePath, ".csv") + ".retry.csv"
+	if err := writeBanListWithFailures(list, entries, failures, retryFilePath); err != nil {
+		log.Printf("[ERROR] Error writing users to retry to file: %v", err)
+		return
+	}
+	log.Printf("[INFO] %d users failed with retryable errors, they are written to %s", len(entries), retryFilePath)
+	log.Printf("[INFO] To retry them, run the same command with --ban-retry-failed flag added")
+}
 
-		id, convErr := strconv.Atoi(record[1])
-		if convErr != nil {
-			log.Printf("[WARN] error converting %s id to int: %v", record[1], convErr)
-			continue
+// banUserAndClearMessages bans users and clears their messages, recording the result for every user in the status file.
+// Stops before the next user once the context is canceled, users which were not processed stay pending.
+func banUserAndClearMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, users []*tg.InputPeerUser, status *banStatusFile, params banParams) {
+	for i, user := range users {
+		// do not attempt to ban users after the context is canceled
+		if ctx.Err() != nil {
+			log.Printf("[INFO] Canceled after processing %d/%d users", i, len(users))
+			return
+		}
+		result := banUser(ctx, api, channel, user, params)
+		if result.breach != "" {
+			// the user stays pending, so after the review the run could be continued with --ban-force
+			log.Printf("[ERROR] Safety limit breached: %s", result.breach)
+			log.Printf("[ERROR] Aborting after processing %d/%d users. Check the ban list, and set --ban-force if it's really intended", i, len(users))
+			return
+		}
+		if ctx.Err() != nil && errors.Is(result.err, ctx.Err()) {
+			// interrupted in the middle, the user stays pending to be processed again on resume
+			log.Printf("[INFO] Canceled while processing user %d, it will be processed again on resume", user.UserID)
+			return
+		}
+		// user who is already gone is banned anyway, which is the desired result
+		userStatus := banStatusDone
+		if result.class != failureNone && result.class != failureGone {
+			userStatus = banStatusFailed
+		}
+		if result.skipReason != "" {
+			userStatus = banStatusSkipped
+			log.Printf("[INFO] Skipped user %d: %s", user.UserID, result.skipReason)
 		}
-		accessHash, accessConvErr := strconv.Atoi(record[2])
-		if convErr != nil {
-			log.Printf("[WARN] error converting %s accessHash to int: %v", record[1], accessConvErr)
-			continue
+		if e := status.set(user.UserID, userStatus, result); e != nil {
+			log.Printf("[ERROR] %v", e)
 		}
-		ids = append(ids, &tg.InputPeerUser{
-			UserID:     int64(id),
-			AccessHash: int64(accessHash),
+		log.Printf("[INFO] Done processing #%d/%d, %d messages of user %d deleted", i+1, len(users), result.deleted, user.UserID)
+	}
+}
+
+// skipReason returns the reason not to touch the user in given state, or empty string if the user should be processed
+func skipReason(info participantInfo, maxMembership time.Duration) string {
+	switch info.state {
+	case participantBanned:
+		return "already banned"
+	case participantLeft, participantAbsent:
+		return "not in the channel"
+	case participantAdmin, participantCreator, participantSelf:
+		return fmt.Sprintf("user is %s, refusing to touch", info.state)
+	}
+	if maxMembership > 0 && !info.joined.IsZero() && time.Since(info.joined) > maxMembership {
+		return fmt.Sprintf("joined %s, earlier than %s ago", info.joined, maxMembership)
+	}
+	return ""
+}
+
+// banResult is the outcome of processing a single user
+type banResult struct {
+	class     failureClass // the most severe failure class of the errors
+	err       error
+	deleted   int    // number of deleted messages
+	remaining int    // number of messages found after the deletion
+	reported  string // result of reporting to Telegram
+	// reason why the user was not touched, as they were already banned or are protected
+	skipReason string
+	// safety limit breached by the user, which aborts the run
+	breach string
+}
+
+// banUser deletes messages of the user in the scope, applies the action to them,
+// and checks that no messages in the scope are left
+func banUser(ctx context.Context, api *tg.Client, channel *tg.Channel, user *tg.InputPeerUser, params banParams) banResult {
+	var result banResult
+	var errs []error
+	var classes []failureClass
+
+	// the ban list could be created days ago, so the current state of the user is checked first
+	info, err := params.resolver.getParticipant(ctx, user)
+	if err != nil {
+		log.Printf("[ERROR] error retrieving the current state of the user %d: %v", user.UserID, err)
+		return banResult{class: classifyError(err), err: fmt.Errorf("error retrieving participant: %w", err)}
+	}
+	if reason := skipReason(info, params.maxMembership); reason != "" {
+		return banResult{skipReason: reason}
+	}
+	breach, err := params.limits.checkUser(ctx, api, channel, user, info)
+	if err != nil {
+		log.Printf("[ERROR] error checking safety limits for the user %d: %v", user.UserID, err)
+		return banResult{class: classifyError(err), err: fmt.Errorf("error checking safety limits: %w", err)}
+	}
+	if breach != "" {
+		return banResult{breach: breach}
+	}
+
+	if params.archive != nil {
+		// without the evidence the destructive actions are not taken
+		archived, e := params.archive.archiveUser(ctx, api, channel, user, params.scope)
+		if e != nil {
+			log.Printf("[ERROR] error archiving messages of the user %d: %v", user.UserID, e)
+			return banResult{class: classifyError(e), err: fmt.Errorf("error archiving: %w", e)}
+		}
+		log.Printf("[DEBUG] %d messages of the user %d archived", archived, user.UserID)
+	}
+	if params.reportSpam || params.reportPeer {
+		log.Printf("[DEBUG] Reporting the user %d to Telegram", user.UserID)
+		result.reported = reportUser(ctx, api, channel, user, params.scope, params.reportSpam, params.reportPeer)
+		log.Printf("[DEBUG] Reporting result for the user %d: %s", user.UserID, result.reported)
+	}
+	log.Printf("[DEBUG] Deleting %s by the user %d", params.scope, user.UserID)
+	deleted, err := deleteUserMessages(ctx, api, channel, user, params.scope)
+	result.deleted = deleted
+	if err != nil {
+		log.Printf("[ERROR] error deleting messages by the user %d: %v", user.UserID, err)
+		errs = append(errs, fmt.Errorf("error deleting messages: %w", err))
+		classes = append(classes, classifyError(err))
+	}
+	if rights, ok := params.action.rights(params.restrictFor); ok {
+		log.Printf("[DEBUG] Applying %s action to user %d", params.action, user.UserID)
+		_, err = api.ChannelsEditBanned(ctx, &tg.ChannelsEditBannedRequest{
+			Channel:      channel.AsInput(),
+			Participant:  user,
+			BannedRights: rights,
 		})
+		if err != nil {
+			log.Printf("[ERROR] error applying %s action to user %d: %v", params.action, user.UserID, err)
+			errs = append(errs, fmt.Errorf("error applying %s action: %w", params.action, err))
+			classes = append(classes, classifyError(err))
+		}
+	}
+	// double-check that the history deletion didn't leave anything behind
+	remaining, err := countRemainingMessages(ctx, api, channel, user, params.scope)
+	if err != nil {
+		log.Printf("[WARN] error checking remaining messages of the user %d: %v", user.UserID, err)
+	}
+	result.remaining = remaining
+	if remaining > 0 {
+		log.Printf("[WARN] %d messages of the user %d remain after the history deletion", remaining, user.UserID)
 	}
-	return ids, nil
+	result.class, result.err = worstFailure(classes...), errors.Join(errs...)
+	return result
 }
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// banListMetaPrefix marks metadata lines at the top of the ban list file
+const banListMetaPrefix = "# "
+
+// utf8BOM is the byte order mark some editors put at the beginning of the file
+const utf8BOM = "\ufeff"
+
+// banListMeta stores provenance of the ban list: which channel and search produced it
+type banListMeta struct {
+	channelID      int64
+	channelTitle   string
+	searchFrom     time.Time
+	searchTo       time.Time
+	searchDuration time.Duration
+	searchOffset   int
+	searchLimit    int
+	ignoreMessages bool
+	created        time.Time
+	revision       string
+	adminPhoneHash string
+}
+
+// newBanListMeta creates metadata for the ban list produced by the search with given parameters
+func newBanListMeta(channel *tg.Channel, params searchParams) banListMeta {
+	return banListMeta{
+		channelID:      channel.ID,
+		channelTitle:   channel.Title,
+		searchFrom:     params.banTo.Add(-params.duration),
+		searchTo:       params.banTo,
+		searchDuration: params.duration,
+		searchOffset:   params.offset,
+		searchLimit:    params.limit,
+		ignoreMessages: params.ignoreMessages,
+		created:        time.Now(),
+		revision:       revision,
+		adminPhoneHash: phoneHash(params.adminPhone),
+	}
+}
+
+// phoneHash returns hex-encoded sha256 of the phone number, so the file doesn't reveal the phone itself
+func phoneHash(phone string) string {
+	if phone == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(phone))
+	return hex.EncodeToString(sum[:])
+}
+
+// isZero returns true if the ban list had no metadata, which is the case for files created by older versions
+func (m banListMeta) isZero() bool {
+	return m.channelID == 0 && m.created.IsZero()
+}
+
+// checkChannel returns error if the ban list was created for the channel other than given one
+func (m banListMeta) checkChannel(channelID int64) error {
+	if m.channelID == 0 || m.channelID == channelID {
+		return nil
+	}
+	return fmt.Errorf("ban list was created for channel %d (%q), but channel %d is used now", m.channelID, m.channelTitle, channelID)
+}
+
+// pairs returns metadata as ordered key-value pairs, empty values are omitted
+func (m banListMeta) pairs() [][2]string {
+	var res [][2]string
+	add := func(key, value string) {
+		if value != "" {
+			res = append(res, [2]string{key, value})
+		}
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	if m.channelID != 0 {
+		add("channel_id", strconv.FormatInt(m.channelID, 10))
+	}
+	add("channel_title", m.channelTitle)
+	add("search_from", formatTime(m.searchFrom))
+	add("search_to", formatTime(m.searchTo))
+	if m.searchDuration != 0 {
+		add("search_duration", m.searchDuration.String())
+	}
+	if !m.searchTo.IsZero() {
+		add("search_offset", strconv.Itoa(m.searchOffset))
+		add("search_limit", strconv.Itoa(m.searchLimit))
+		add("search_ignore_messages", strconv.FormatBool(m.ignoreMessages))
+	}
+	add("created", formatTime(m.created))
+	add("revision", m.revision)
+	add("admin_phone_sha256", m.adminPhoneHash)
+	return res
+}
+
+// write writes metadata lines to given writer
+func (m banListMeta) write(w io.Writer) error {
+	for _, kv := range m.pairs() {
+		// newlines in the channel title would break the metadata block
+		value := strings.ReplaceAll(kv[1], "\n", " ")
+		if _, err := fmt.Fprintf(w, "%s%s: %s\n", banListMetaPrefix, kv[0], value); err != nil {
+			return fmt.Errorf("error writing ban list metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// readBanListMeta reads metadata lines from the beginning of the ban list,
+// leaving the reader at the header, and returns the number of lines read
+func readBanListMeta(r *bufio.Reader) (meta banListMeta, lines int, err error) {
+	for {
+		prefix, e := r.Peek(len(banListMetaPrefix))
+		if e != nil || string(prefix) != banListMetaPrefix {
+			return meta, lines, nil
+		}
+		line, e := r.ReadString('\n')
+		if e != nil && !errors.Is(e, io.EOF) {
+			return banListMeta{}, lines, fmt.Errorf("error reading metadata: %w", e)
+		}
+		lines++
+		key, value, ok := strings.Cut(strings.TrimRight(strings.TrimPrefix(line, banListMetaPrefix), "\r\n"), ": ")
+		if !ok {
+			continue
+		}
+		if err = meta.set(key, value); err != nil {
+			return banListMeta{}, lines, fmt.Errorf("error parsing metadata: %w", err)
+		}
+	}
+}
+
+// set sets metadata field by its key, unknown keys are ignored
+func (m *banListMeta) set(key, value string) (err error) {
+	parseTime := func(v string) (time.Time, error) { return time.Parse(time.RFC3339, v) }
+	switch key {
+	case "channel_id":
+		m.channelID, err = strconv.ParseInt(value, 10, 64)
+	case "channel_title":
+		m.channelTitle = value
+	case "search_from":
+		m.searchFrom, err = parseTime(value)
+	case "search_to":
+		m.searchTo, err = parseTime(value)
+	case "search_duration":
+		m.searchDuration, err = time.ParseDuration(value)
+	case "search_offset":
+		m.searchOffset, err = strconv.Atoi(value)
+	case "search_limit":
+		m.searchLimit, err = strconv.Atoi(value)
+	case "search_ignore_messages":
+		m.ignoreMessages, err = strconv.ParseBool(value)
+	case "created":
+		m.created, err = parseTime(value)
+	case "revision":
+		m.revision = value
+	case "admin_phone_sha256":
+		m.adminPhoneHash = value
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q: %w", key, value, err)
+	}
+	return nil
+}
+
+// banList is the parsed and validated ban list file
+type banList struct {
+	meta     banListMeta
+	header   []string
+	entries  []banListEntry
+	rejected []banListRejected
+}
+
+// banListEntry is a single valid row of the ban list
+type banListEntry struct {
+	line   int      // line number in the file, for the reports
+	record []string // original row, so that columns added by reviewers are not lost
+	user   banUserInfo
+}
+
+// banListRejected is a single row of the ban list which didn't pass validation
+type banListRejected struct {
+	line   int
+	reason string
+}
+
+// column names of the ban list, after normalization with normalizeColumnName
+const (
+	columnJoined     = "joined"
+	columnUserID     = "userid"
+	columnAccessHash = "accesshash"
+	columnUsername   = "username"
+	columnFirstName  = "firstname"
+	columnLastName   = "lastname"
+	columnMessage    = "message"
+	columnMessageID  = "messageid"
+)
+
+// normalizeColumnName makes "userID", "user_id" and "User ID" the same column
+func normalizeColumnName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '_', '-', ' ', '\ufeff': // BOM is added by some spreadsheet editors
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(name)))
+}
+
+// inputPeer returns the peer to use in Telegram API calls
+func (e banListEntry) inputPeer() *tg.InputPeerUser {
+	return &tg.InputPeerUser{UserID: e.user.userID, AccessHash: e.user.accessHash}
+}
+
+// readBanList reads tab-separated ban list with columns mapped by the header names,
+// every row is validated and the ones which didn't pass are returned in rejected list with the reason
+func readBanList(filePath string) (banList, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return banList{}, fmt.Errorf("error opening %s: %w", filePath, err)
+	}
+	defer f.Close()
+	list, err := parseBanList(f)
+	if err != nil {
+		return banList{}, fmt.Errorf("error reading %s: %w", filePath, err)
+	}
+	return list, nil
+}
+
+// parseBanList parses the ban list, see readBanList. Only the leading metadata block is skipped,
+// rows starting with '#' after it are data and are validated as any other row.
+func parseBanList(in io.Reader) (banList, error) {
+	br := bufio.NewReader(in)
+	if bom, e := br.Peek(len(utf8BOM)); e == nil && string(bom) == utf8BOM {
+		_, _ = br.Discard(len(utf8BOM)) // added by some spreadsheet editors
+	}
+	meta, metaLines, err := readBanListMeta(br)
+	if err != nil {
+		return banList{}, err
+	}
+
+	r := csv.NewReader(br)
+	r.Comma = '\t'
+	r.FieldsPerRecord = -1 // spreadsheets drop trailing empty columns, rows are validated below
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return banList{}, errors.New("no header, the file is empty")
+	}
+	if err != nil {
+		return banList{}, fmt.Errorf("error reading header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		if _, ok := columns[normalizeColumnName(name)]; !ok {
+			columns[normalizeColumnName(name)] = i
+		}
+	}
+	for _, required := range []string{columnUserID, columnAccessHash} {
+		if _, ok := columns[required]; !ok {
+			return banList{}, fmt.Errorf("no %q column in the header %v", required, header)
+		}
+	}
+
+	result := banList{meta: meta, header: header}
+	seen := map[int64]int{}
+	for {
+		record, e := r.Read()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(e, &parseErr) {
+			result.rejected = append(result.rejected, banListRejected{line: metaLines + parseErr.Line, reason: parseErr.Err.Error()})
+			continue
+		}
+		if e != nil {
+			return banList{}, e
+		}
+		line, _ := r.FieldPos(0)
+		line += metaLines
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue // empty row left after editing in spreadsheet
+		}
+
+		user, reason := parseBanListRecord(record, columns)
+		if reason != "" && strings.HasPrefix(record[0], "#") {
+			reason = "comment outside of the leading metadata block, or " + reason
+		}
+		if reason == "" {
+			if prevLine, ok := seen[user.userID]; ok {
+				reason = fmt.Sprintf("duplicate of the line %d", prevLine)
+			}
+		}
+		if reason != "" {
+			result.rejected = append(result.rejected, banListRejected{line: line, reason: reason})
+			continue
+		}
+		seen[user.userID] = line
+		result.entries = append(result.entries, banListEntry{line: line, record: record, user: user})
+	}
+	return result, nil
+}
+
+// parseBanListRecord validates single ban list row and returns the user from it,
+// or the reason why the row is rejected
+func parseBanListRecord(record []string, columns map[string]int) (user banUserInfo, reason string) {
+	get := func(column string) string {
+		if i, ok := columns[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	userID := get(columnUserID)
+	if userID == "" {
+		return banUserInfo{}, "empty userID"
+	}
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil || id <= 0 {
+		return banUserInfo{}, fmt.Sprintf("invalid userID %q", userID)
+	}
+
+	accessHash := get(columnAccessHash)
+	if accessHash == "" {
+		return banUserInfo{}, "empty access_hash"
+	}
+	hash, err := strconv.ParseInt(accessHash, 10, 64)
+	if err != nil {
+		return banUserInfo{}, fmt.Sprintf("invalid access_hash %q", accessHash)
+	}
+
+	var joined time.Time
+	if v := get(columnJoined); v != "" {
+		if joined, err = time.Parse(time.RFC3339, v); err != nil {
+			return banUserInfo{}, fmt.Sprintf("invalid joined time %q, RFC3339 expected", v)
+		}
+	}
+
+	var messageID int
+	if v := get(columnMessageID); v != "" {
+		if messageID, err = strconv.Atoi(v); err != nil || messageID < 0 {
+			return banUserInfo{}, fmt.Sprintf("invalid message_id %q", v)
+		}
+	}
+
+	return banUserInfo{
+		userID:     id,
+		accessHash: hash,
+		joined:     joined,
+		messageID:  messageID,
+		username:   get(columnUsername),
+		firstName:  get(columnFirstName),
+		lastName:   get(columnLastName),
+		message:    get(columnMessage),
+	}, ""
+}
+
+// logRejected prints the report about rejected rows of the ban list
+func (l banList) logRejected(filePath string) {
+	if len(l.rejected) == 0 {
+		return
+	}
+	log.Printf("[WARN] %d of %d rows of %s are rejected:", len(l.rejected), len(l.rejected)+len(l.entries), filePath)
+	for _, r := range l.rejected {
+		log.Printf("[WARN] line %d: %s", r.line, r.reason)
+	}
+}
+
+// writeBanListWithFailures writes given entries of the ban list to a new file with the same metadata and columns,
+// setting failure class and error columns (adding them if they are not present yet)
+func writeBanListWithFailures(list banList, entries []banListEntry, failures []banStatusEntry, fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %w", fileName, err)
+	}
+	defer func() {
+		if e := file.Close(); e != nil {
+			log.Printf("[ERROR] Error closing file %s: %v", fileName, e)
+		}
+	}()
+	if err = list.meta.write(file); err != nil {
+		return err
+	}
+
+	header := append([]string{}, list.header...)
+	columnIndex := func(name string) int {
+		for i, h := range header {
+			if normalizeColumnName(h) == normalizeColumnName(name) {
+				return i
+			}
+		}
+		header = append(header, name)
+		return len(header) - 1
+	}
+	classIdx, errIdx := columnIndex("error_class"), columnIndex("error")
+
+	writer := csv.NewWriter(file)
+	writer.Comma = '\t'
+	if err = writer.Write(header); err != nil {
+		return fmt.Errorf("error writing row to csv: %w", err)
+	}
+	for i, entry := range entries {
+		record := make([]string, len(header))
+		copy(record, entry.record)
+		record[classIdx] = string(failures[i].class)
+		record[errIdx] = strings.ReplaceAll(failures[i].err, "\t", " ")
+		if err = writer.Write(record); err != nil {
+			return fmt.Errorf("error writing row to csv: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
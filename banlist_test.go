@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBanList(t *testing.T) {
+	joined := time.Date(2022, 10, 31, 19, 30, 15, 0, time.UTC)
+	tests := []struct {
+		name     string
+		input    string
+		err      string
+		meta     banListMeta
+		users    []banUserInfo
+		lines    []int // lines of the entries
+		rejected []banListRejected
+	}{
+		{
+			name: "written by the search",
+			input: "# channel_id: 1234567\n# channel_title: test channel\n# created: 2022-10-31T19:30:15Z\n" +
+				"joined\tuserID\taccess_hash\tusername\tfirstName\tlastName\tmessage\tmessage_id\n" +
+				"2022-10-31T19:30:15Z\t1\t-100\tspammer\tJohn\tDoe\tbuy now\t42\n" +
+				"\t2\t200\t\t\t\t\t\n",
+			meta: banListMeta{channelID: 1234567, channelTitle: "test channel", created: joined},
+			users: []banUserInfo{
+				{userID: 1, accessHash: -100, joined: joined, username: "spammer", firstName: "John", lastName: "Doe", message: "buy now", messageID: 42},
+				{userID: 2, accessHash: 200},
+			},
+			lines: []int{5, 6},
+		},
+		{
+			name:  "header normalization, reordered and extra columns",
+			input: "Message\tnotes\tAccess Hash\tUser-ID\n#hashtag spam\tlooks bad\t300\t3\n",
+			users: []banUserInfo{{userID: 3, accessHash: 300, message: "#hashtag spam"}},
+			lines: []int{2},
+		},
+		{
+			name:  "BOM before the header",
+			input: "\ufeffuserID\taccess_hash\n4\t400\n",
+			users: []banUserInfo{{userID: 4, accessHash: 400}},
+			lines: []int{2},
+		},
+		{
+			name:  "BOM before the metadata",
+			input: "\ufeff# channel_id: 7\nuserID\taccess_hash\n4\t400\n",
+			meta:  banListMeta{channelID: 7},
+			users: []banUserInfo{{userID: 4, accessHash: 400}},
+			lines: []int{3},
+		},
+		{
+			name:  "trailing empty columns dropped and empty rows",
+			input: "userID\taccess_hash\tusername\n5\t500\n\n\t\t\n6\t600\tname\n",
+			users: []banUserInfo{{userID: 5, accessHash: 500}, {userID: 6, accessHash: 600, username: "name"}},
+			lines: []int{2, 5},
+		},
+		{
+			name:  "duplicate rows",
+			input: "userID\taccess_hash\n7\t700\n7\t700\n",
+			users: []banUserInfo{{userID: 7, accessHash: 700}},
+			lines: []int{2},
+			rejected: []banListRejected{
+				{line: 3, reason: "duplicate of the line 2"},
+			},
+		},
+		{
+			name: "invalid values",
+			input: "# channel_id: 1\nuserID\taccess_hash\tjoined\tmessage_id\n" +
+				"abc\t1\n-8\t1\n\t1\n9\tx1\n9\t\n10\t1\tyesterday\n11\t1\t\t-1\n12\t1200\n",
+			meta:  banListMeta{channelID: 1},
+			users: []banUserInfo{{userID: 12, accessHash: 1200}},
+			lines: []int{10},
+			rejected: []banListRejected{
+				{line: 3, reason: `invalid userID "abc"`},
+				{line: 4, reason: `invalid userID "-8"`},
+				{line: 5, reason: "empty userID"},
+				{line: 6, reason: `invalid access_hash "x1"`},
+				{line: 7, reason: "empty access_hash"},
+				{line: 8, reason: `invalid joined time "yesterday", RFC3339 expected`},
+				{line: 9, reason: `invalid message_id "-1"`},
+			},
+		},
+		{
+			name:  "comment after the metadata block is rejected",
+			input: "# channel_id: 1\nuserID\taccess_hash\n# note: check later\n13\t1300\n",
+			meta:  banListMeta{channelID: 1},
+			users: []banUserInfo{{userID: 13, accessHash: 1300}},
+			lines: []int{4},
+			rejected: []banListRejected{
+				{line: 3, reason: `comment outside of the leading metadata block, or invalid userID "# note: check later"`},
+			},
+		},
+		{name: "empty", input: "", err: "no header, the file is empty"},
+		{name: "only metadata", input: "# channel_id: 1\n", err: "no header, the file is empty"},
+		{name: "no access hash column", input: "userID\tusername\n1\tname\n", err: `no "accesshash" column`},
+		{name: "invalid metadata", input: "# channel_id: abc\nuserID\taccess_hash\n", err: "invalid channel_id value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := parseBanList(strings.NewReader(tt.input))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if list.meta != tt.meta {
+				t.Errorf("meta: expected %+v, got %+v", tt.meta, list.meta)
+			}
+			var users []banUserInfo
+			var lines []int
+			for _, e := range list.entries {
+				users = append(users, e.user)
+				lines = append(lines, e.line)
+			}
+			if !reflect.DeepEqual(users, tt.users) {
+				t.Errorf("users: expected %+v, got %+v", tt.users, users)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("lines: expected %v, got %v", tt.lines, lines)
+			}
+			if !reflect.DeepEqual(list.rejected, tt.rejected) {
+				t.Errorf("rejected: expected %+v, got %+v", tt.rejected, list.rejected)
+			}
+		})
+	}
+}
+
+func TestNormalizeColumnName(t *testing.T) {
+	tests := map[string]string{
+		"userID":         columnUserID,
+		"user_id":        columnUserID,
+		" User ID ":      columnUserID,
+		"\ufeffuserID":   columnUserID,
+		"access-hash":    columnAccessHash,
+		"Access_Hash":    columnAccessHash,
+		"message_id":     columnMessageID,
+		"reviewer notes": "reviewernotes",
+		"First Name":     columnFirstName,
+		"joined":         columnJoined,
+		"":               "",
+		"\t":             "",
+	}
+	for name, expected := range tests {
+		if got := normalizeColumnName(name); got != expected {
+			t.Errorf("normalizeColumnName(%q): expected %q, got %q", name, expected, got)
+		}
+	}
+}
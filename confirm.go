@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+const (
+	histogramBuckets  = 10
+	histogramBarWidth = 40
+	summarySampleSize = 5
+)
+
+// printBanSummary prints what is going to be done: the target channel, the users and the restriction profile
+func printBanSummary(w io.Writer, channel *tg.Channel, entries []banListEntry, params banParams) {
+	fmt.Fprintf(w, "\nTarget channel: %q (id %d)\n", channel.Title, channel.ID)
+	fmt.Fprintf(w, "Users to process: %d\n", len(entries))
+	fmt.Fprintf(w, "Messages to delete: %s\n", params.scope)
+	fmt.Fprintf(w, "Action: %s", params.action)
+	if rights, ok := params.action.rights(params.restrictFor); ok {
+		until := "forever"
+		if rights.UntilDate != 0 {
+			until = "until " + time.Unix(int64(rights.UntilDate), 0).String()
+		}
+		fmt.Fprintf(w, " (%s, %s)", strings.Join(takenRights(rights), ", "), until)
+	}
+	fmt.Fprintln(w)
+	if params.archiveDir != "" {
+		fmt.Fprintf(w, "Evidence archive: %s\n", params.archiveDir)
+	}
+	if params.reportSpam || params.reportPeer {
+		fmt.Fprintln(w, "Users are reported to Telegram")
+	}
+
+	fmt.Fprintln(w, "\nJoin dates:")
+	for _, line := range joinHistogram(entries) {
+		fmt.Fprintln(w, line)
+	}
+
+	fmt.Fprintln(w, "\nSample of users:")
+	for _, entry := range sampleEntries(entries, summarySampleSize) {
+		u := entry.user
+		name := strings.TrimSpace(u.firstName + " " + u.lastName)
+		if u.username != "" {
+			name = fmt.Sprintf("@%s %s", u.username, name)
+		}
+		message := strings.ReplaceAll(u.message, "\n", " ")
+		if len([]rune(message)) > 50 {
+			message = string([]rune(message)[:45]) + "... (truncated)"
+		}
+		fmt.Fprintf(w, "  %d %s: %s\n", u.userID, name, message)
+	}
+	fmt.Fprintln(w)
+}
+
+// confirmBan asks to type the channel title or the number of users to proceed, returns true if confirmed
+func confirmBan(ctx context.Context, r io.Reader, w io.Writer, channel *tg.Channel, usersCount int) bool {
+	fmt.Fprintf(w, "Type the channel title or the number of users to process (%d) to proceed: ", usersCount)
+	answer := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		answer <- strings.TrimSpace(line)
+	}()
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(w)
+		return false
+	case a := <-answer:
+		return a != "" && (a == channel.Title || a == strconv.Itoa(usersCount))
+	}
+}
+
+// takenRights returns human-readable names of the rights taken away
+func takenRights(rights tg.ChatBannedRights) []string {
+	names := []struct {
+		taken bool
+		name  string
+	}{
+		{rights.ViewMessages, "view messages"},
+		{rights.SendMessages, "send messages"},
+		{rights.SendMedia, "send media"},
+		{rights.SendStickers, "send stickers"},
+		{rights.SendGifs, "send gifs"},
+		{rights.SendGames, "send games"},
+		{rights.SendInline, "use inline bots"},
+		{rights.EmbedLinks, "embed links"},
+		{rights.SendPolls, "send polls"},
+		{rights.ChangeInfo, "change info"},
+		{rights.InviteUsers, "invite users"},
+		{rights.PinMessages, "pin messages"},
+	}
+	var res []string
+	for _, n := range names {
+		if n.taken {
+			res = append(res, "no "+n.name)
+		}
+	}
+	return res
+}
+
+// joinHistogram returns lines of the text histogram of the users join dates
+func joinHistogram(entries []banListEntry) []string {
+	var dates []time.Time
+	var unknown int
+	for _, e := range entries {
+		if e.user.joined.IsZero() {
+			unknown++
+			continue
+		}
+		dates = append(dates, e.user.joined)
+	}
+	var res []string
+	if len(dates) > 0 {
+		sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+		from, to := dates[0], dates[len(dates)-1]
+		step := to.Sub(from) / histogramBuckets
+		if step <= 0 {
+			step = time.Second
+		}
+		counts := make([]int, histogramBuckets)
+		for _, d := range dates {
+			counts[min(int(d.Sub(from)/step), histogramBuckets-1)]++
+		}
+		maxCount := 0
+		for _, c := range counts {
+			maxCount = max(maxCount, c)
+		}
+		for i, c := range counts {
+			if c == 0 && step == time.Second {
+				continue // all users joined at the same second
+			}
+			bar := strings.Repeat("#", c*histogramBarWidth/maxCount)
+			res = append(res, fmt.Sprintf("  %s %6d %s", from.Add(step*time.Duration(i)).Format("2006-01-02 15:04:05"), c, bar))
+		}
+	}
+	if unknown > 0 {
+		res = append(res, fmt.Sprintf("  %-19s %6d", "unknown", unknown))
+	}
+	return res
+}
+
+// sampleEntries returns up to n entries evenly spread over the list
+func sampleEntries(entries []banListEntry, n int) []banListEntry {
+	if len(entries) <= n {
+		return entries
+	}
+	res := make([]banListEntry, 0, n)
+	for i := 0; i < n; i++ {
+		res = append(res, entries[i*len(entries)/n])
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/gotd/td/tgerr"
+)
+
+// failureClass is the kind of the error returned by Telegram API during the ban run
+type failureClass string
+
+const (
+	failureNone        failureClass = ""
+	failureGone        failureClass = "already-gone"
+	failurePermission  failureClass = "permission"
+	failureInvalidPeer failureClass = "invalid-peer"
+	failureTransient   failureClass = "transient"
+	failureOther       failureClass = "other"
+)
+
+// failureClasses lists all failure classes from the most to the least severe
+var failureClasses = []failureClass{failurePermission, failureInvalidPeer, failureTransient, failureOther, failureGone}
+
+// classifyError returns the failure class for the error returned by Telegram API
+func classifyError(err error) failureClass {
+	if err == nil {
+		return failureNone
+	}
+	if _, ok := tgerr.AsFloodWait(err); ok {
+		return failureTransient
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		return failureTransient
+	}
+	rpcErr, ok := tgerr.As(err)
+	if !ok {
+		return failureOther
+	}
+	switch {
+	case rpcErr.IsOneOf("USER_NOT_PARTICIPANT", "USER_DELETED", "INPUT_USER_DEACTIVATED", "USER_DEACTIVATED"):
+		return failureGone
+	case rpcErr.IsOneOf("CHAT_ADMIN_REQUIRED", "RIGHT_FORBIDDEN", "USER_ADMIN_INVALID", "USER_CREATOR",
+		"CHANNEL_PRIVATE", "CHAT_WRITE_FORBIDDEN"), rpcErr.IsCode(403):
+		return failurePermission
+	case rpcErr.IsOneOf("PEER_ID_INVALID", "USER_ID_INVALID", "PARTICIPANT_ID_INVALID", "INPUT_USER_INVALID"):
+		return failureInvalidPeer
+	case rpcErr.IsCodeOneOf(420, 500, 503), rpcErr.IsOneOf("TIMEOUT", "RPC_CALL_FAIL", "RPC_MCGET_FAIL"):
+		return failureTransient
+	}
+	return failureOther
+}
+
+// worstFailure returns the most severe failure class of the given ones
+func worstFailure(classes ...failureClass) failureClass {
+	for _, c := range failureClasses {
+		for _, cc := range classes {
+			if c == cc {
+				return c
+			}
+		}
+	}
+	return failureNone
+}
+
+// retryable returns true if the failure could go away when the same action is retried later
+func (c failureClass) retryable() bool {
+	return c == failureTransient || c == failureOther
+}
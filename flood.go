@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// responses to the message flood, applied in order to the consecutive floods of the same user
+const (
+	floodStepDelete = "delete" // delete the flood messages
+	floodStepMute   = "mute"   // delete the flood messages and forbid the user to send anything for a while
+	floodStepBan    = "ban"    // ban the user and delete all their messages
+)
+
+// floodParams are the settings of the per-user message flood detection
+type floodParams struct {
+	messages   int           // that many messages within the window is a flood, 0 disables the check
+	duplicates int           // that many identical messages within the window is a flood, 0 disables the check
+	window     time.Duration // sliding window of the detection
+	steps      []string      // escalating responses to the consecutive floods of the same user
+	mute       time.Duration // duration of the mute step
+	forget     time.Duration // floods are forgotten after that time without new ones, so the escalation starts over
+}
+
+// enabled returns true if any of the flood checks is enabled
+func (p floodParams) enabled() bool {
+	return p.messages > 0 || p.duplicates > 0
+}
+
+// floodMessage is the recent message of the user
+type floodMessage struct {
+	id   int
+	at   time.Time
+	text string // normalized text to detect duplicates, empty for messages without text
+}
+
+// floodUser is the recent activity of a single user
+type floodUser struct {
+	messages  []floodMessage // messages within the window
+	floods    int            // floods detected so far
+	lastFlood time.Time      // time of the last flood
+}
+
+// forgotten returns true if the floods of the user are forgotten by given time
+func (u *floodUser) forgotten(now time.Time, forget time.Duration) bool {
+	return u.floods == 0 || (forget > 0 && now.Sub(u.lastFlood) > forget)
+}
+
+// flood is the detected flood of the user along with the response to it
+type flood struct {
+	reason   string
+	step     string
+	messages []int // IDs of the flood messages
+}
+
+// floodDetector tracks message rate and duplicate messages rate of every user over the sliding window
+type floodDetector struct {
+	params floodParams
+
+	mu    sync.Mutex
+	users map[int64]*floodUser
+}
+
+// newFloodDetector creates detector with given settings
+func newFloodDetector(params floodParams) *floodDetector {
+	return &floodDetector{params: params, users: map[int64]*floodUser{}}
+}
+
+// add records the message of the user and returns the flood if it's detected.
+// Messages of the detected flood are forgotten, so the next flood is counted from the scratch.
+func (d *floodDetector) add(userID int64, msg *tg.Message) (flood, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Unix(int64(msg.Date), 0)
+	u, ok := d.users[userID]
+	if !ok {
+		u = &floodUser{}
+		d.users[userID] = u
+	}
+	if u.forgotten(now, d.params.forget) {
+		u.floods = 0
+	}
+	u.messages = append(pruneFloodMessages(u.messages, now, d.params.window), floodMessage{
+		id:   msg.ID,
+		at:   now,
+		text: strings.ToLower(strings.Join(strings.Fields(msg.Message), " ")),
+	})
+
+	var f flood
+	switch {
+	case d.params.messages > 0 && len(u.messages) >= d.params.messages:
+		f.reason = fmt.Sprintf("%d messages within %s", len(u.messages), d.params.window)
+		for _, m := range u.messages {
+			f.messages = append(f.messages, m.id)
+		}
+	case d.params.duplicates > 0 && u.messages[len(u.messages)-1].text != "":
+		last := u.messages[len(u.messages)-1].text
+		for _, m := range u.messages {
+			if m.text == last {
+				f.messages = append(f.messages, m.id)
+			}
+		}
+		if len(f.messages) < d.params.duplicates {
+			return flood{}, false
+		}
+		f.reason = fmt.Sprintf("%d identical messages within %s", len(f.messages), d.params.window)
+	default:
+		return flood{}, false
+	}
+	f.step = d.params.steps[min(u.floods, len(d.params.steps)-1)]
+	u.floods++
+	u.lastFlood = now
+	u.messages = nil
+	return f, true
+}
+
+// prune forgets users without messages within the window and without floods not forgotten yet
+func (d *floodDetector) prune(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, u := range d.users {
+		u.messages = pruneFloodMessages(u.messages, now, d.params.window)
+		if len(u.messages) == 0 && u.forgotten(now, d.params.forget) {
+			delete(d.users, id)
+		}
+	}
+}
+
+// pruneFloodMessages returns messages not older than the window
+func pruneFloodMessages(messages []floodMessage, now time.Time, window time.Duration) []floodMessage {
+	i := 0
+	for i < len(messages) && now.Sub(messages[i].at) > window {
+		i++
+	}
+	return messages[i:]
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestFloodDetectorAdd(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	type message struct {
+		at   time.Duration // since the start
+		text string
+	}
+	tests := []struct {
+		name     string
+		params   floodParams
+		messages []message
+		floods   map[int]flood // by the message index
+	}{
+		{
+			name:   "message rate, window is cleared after the flood and the last step is repeated",
+			params: floodParams{messages: 3, window: 10 * time.Second, steps: []string{floodStepDelete, floodStepMute}},
+			messages: []message{
+				{0, "a"}, {time.Second, "b"}, {2 * time.Second, "c"},
+				{3 * time.Second, "d"}, // counted from the scratch after the flood
+				{4 * time.Second, "e"}, {5 * time.Second, "f"},
+				{6 * time.Second, "g"}, {7 * time.Second, "h"}, {8 * time.Second, "i"},
+			},
+			floods: map[int]flood{
+				2: {reason: "3 messages within 10s", step: floodStepDelete, messages: []int{1, 2, 3}},
+				5: {reason: "3 messages within 10s", step: floodStepMute, messages: []int{4, 5, 6}},
+				8: {reason: "3 messages within 10s", step: floodStepMute, messages: []int{7, 8, 9}},
+			},
+		},
+		{
+			name:     "messages outside of the window",
+			params:   floodParams{messages: 3, window: 10 * time.Second, steps: []string{floodStepDelete}},
+			messages: []message{{0, "a"}, {11 * time.Second, "b"}, {22 * time.Second, "c"}, {33 * time.Second, "d"}},
+		},
+		{
+			name:   "duplicates are normalized, other messages and empty texts are not counted",
+			params: floodParams{duplicates: 3, window: time.Minute, steps: []string{floodStepBan}},
+			messages: []message{
+				{0, "Buy  NOW"}, {time.Second, ""}, {2 * time.Second, "hello"}, {3 * time.Second, ""},
+				{4 * time.Second, "buy now"}, {5 * time.Second, " buy\nnow "},
+			},
+			floods: map[int]flood{
+				5: {reason: "3 identical messages within 1m0s", step: floodStepBan, messages: []int{1, 5, 6}},
+			},
+		},
+		{
+			name:     "empty texts are not duplicates",
+			params:   floodParams{duplicates: 2, window: time.Minute, steps: []string{floodStepDelete}},
+			messages: []message{{0, ""}, {time.Second, ""}, {2 * time.Second, ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newFloodDetector(tt.params)
+			for i, m := range tt.messages {
+				msg := &tg.Message{ID: i + 1, Date: int(start.Add(m.at).Unix()), Message: m.text}
+				f, ok := d.add(1, msg)
+				expected, expectedOk := tt.floods[i]
+				if ok != expectedOk || !reflect.DeepEqual(f, expected) {
+					t.Errorf("message %d: expected %+v (%v), got %+v (%v)", i, expected, expectedOk, f, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestFloodDetectorPrune(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := newFloodDetector(floodParams{messages: 2, window: time.Minute, steps: []string{floodStepDelete, floodStepBan}})
+	d.add(1, &tg.Message{ID: 1, Date: int(start.Unix())})
+	d.add(2, &tg.Message{ID: 2, Date: int(start.Unix())})
+	if _, ok := d.add(2, &tg.Message{ID: 3, Date: int(start.Unix())}); !ok {
+		t.Fatal("expected the flood of the user 2")
+	}
+	d.prune(start.Add(2 * time.Minute))
+	if _, ok := d.users[1]; ok {
+		t.Error("user 1 without messages within the window and floods is not forgotten")
+	}
+	if _, ok := d.users[2]; !ok {
+		t.Fatal("user 2 with the flood is forgotten")
+	}
+	d.add(2, &tg.Message{ID: 4, Date: int(start.Add(3 * time.Minute).Unix())})
+	f, ok := d.add(2, &tg.Message{ID: 5, Date: int(start.Add(3 * time.Minute).Unix())})
+	if !ok || f.step != floodStepBan {
+		t.Errorf("expected the second flood of the user 2 to be responded with %s, got %+v (%v)", floodStepBan, f, ok)
+	}
+}
+
+func TestFloodDetectorForget(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := newFloodDetector(floodParams{messages: 2, window: time.Minute, steps: []string{floodStepDelete, floodStepMute, floodStepBan}, forget: time.Hour})
+	floodAt := func(at time.Time) flood {
+		t.Helper()
+		d.add(1, &tg.Message{ID: 1, Date: int(at.Unix())})
+		f, ok := d.add(1, &tg.Message{ID: 2, Date: int(at.Unix())})
+		if !ok {
+			t.Fatalf("expected the flood at %s", at)
+		}
+		return f
+	}
+	if f := floodAt(start); f.step != floodStepDelete {
+		t.Errorf("first flood: expected %s, got %s", floodStepDelete, f.step)
+	}
+	if f := floodAt(start.Add(30 * time.Minute)); f.step != floodStepMute {
+		t.Errorf("second flood within the forget time: expected %s, got %s", floodStepMute, f.step)
+	}
+	if f := floodAt(start.Add(3 * time.Hour)); f.step != floodStepDelete {
+		t.Errorf("flood after the forget time: expected the escalation to start over with %s, got %s", floodStepDelete, f.step)
+	}
+	d.prune(start.Add(3*time.Hour + 30*time.Minute))
+	if _, ok := d.users[1]; !ok {
+		t.Fatal("user with the recent flood is forgotten")
+	}
+	d.prune(start.Add(5 * time.Hour))
+	if _, ok := d.users[1]; ok {
+		t.Error("user without floods within the forget time is not forgotten")
+	}
+}
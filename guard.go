@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// safetyLimits are the guardrails of the ban run, breaching any of them aborts the run unless force is set.
+// Zero values disable the corresponding limit.
+type safetyLimits struct {
+	maxUsers    int           // maximum number of users processed in a single run
+	maxShare    float64       // maximum share of the channel members processed in a single run, in percent
+	maxMessages int           // users with more messages than that are established members
+	maxAge      time.Duration // users who joined earlier than that long ago are established members
+	force       bool          // ignore the limits
+}
+
+// checkRun returns the limits breached by the run with given number of users,
+// or nil if the run is within the limits or they are forced
+func (l safetyLimits) checkRun(ctx context.Context, api *tg.Client, channel *tg.Channel, usersCount int) ([]string, error) {
+	if l.force {
+		return nil, nil
+	}
+	var breaches []string
+	if l.maxUsers > 0 && usersCount > l.maxUsers {
+		breaches = append(breaches, fmt.Sprintf("%d users to process, more than the limit of %d per run", usersCount, l.maxUsers))
+	}
+	if l.maxShare > 0 {
+		members, err := getMembersCount(ctx, api, channel)
+		if err != nil {
+			return nil, err
+		}
+		if share := float64(usersCount) * 100 / float64(max(members, 1)); share > l.maxShare {
+			breaches = append(breaches, fmt.Sprintf("%d users to process is %.1f%% of %d channel members, more than the limit of %.1f%%",
+				usersCount, share, members, l.maxShare))
+		}
+	}
+	return breaches, nil
+}
+
+// checkUser returns the breached limit protecting established members, or empty string if the user could be processed
+func (l safetyLimits) checkUser(ctx context.Context, api *tg.Client, channel *tg.Channel, user *tg.InputPeerUser, info participantInfo) (string, error) {
+	if l.force {
+		return "", nil
+	}
+	if l.maxAge > 0 && !info.joined.IsZero() && time.Since(info.joined) > l.maxAge {
+		return fmt.Sprintf("user %d joined %s, earlier than the limit of %s ago", user.UserID, info.joined, l.maxAge), nil
+	}
+	if l.maxMessages > 0 {
+		messages, err := countUserMessages(ctx, api, channel, user)
+		if err != nil {
+			return "", err
+		}
+		if messages > l.maxMessages {
+			return fmt.Sprintf("user %d has %d messages, more than the limit of %d", user.UserID, messages, l.maxMessages), nil
+		}
+	}
+	return "", nil
+}
+
+// getMembersCount returns number of members of the channel
+func getMembersCount(ctx context.Context, api *tg.Client, channel *tg.Channel) (int, error) {
+	if count, ok := channel.GetParticipantsCount(); ok && count > 0 {
+		return count, nil
+	}
+	full, err := api.ChannelsGetFullChannel(ctx, channel.AsInput())
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving channel members count: %w", err)
+	}
+	if channelFull, ok := full.FullChat.(*tg.ChannelFull); ok {
+		if count, ok := channelFull.GetParticipantsCount(); ok {
+			return count, nil
+		}
+	}
+	return 0, fmt.Errorf("channel members count is not available")
+}
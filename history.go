@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// maxHistoryDeleteCalls limits the number of ChannelsDeleteParticipantHistory calls for a single user,
+// in case Telegram keeps returning non-zero offset for some reason
+const maxHistoryDeleteCalls = 1000
+
+// messagesBatchSize is the maximum number of messages Telegram API returns or deletes in a single call
+const messagesBatchSize = 100
+
+// deleteScope limits the deletion to the messages sent within the time range and matching the content rule,
+// zero value means the whole history of the user is deleted
+type deleteScope struct {
+	from  time.Time
+	to    time.Time
+	match *regexp.Regexp
+}
+
+// isSet returns true if the deletion is limited to some of the messages
+func (s deleteScope) isSet() bool {
+	return !s.from.IsZero() || !s.to.IsZero() || s.match != nil
+}
+
+// String returns human-readable description of the scope
+func (s deleteScope) String() string {
+	if !s.isSet() {
+		return "whole history"
+	}
+	res := "messages"
+	if !s.from.IsZero() {
+		res += fmt.Sprintf(" from %s", s.from)
+	}
+	if !s.to.IsZero() {
+		res += fmt.Sprintf(" to %s", s.to)
+	}
+	if s.match != nil {
+		res += fmt.Sprintf(" matching %q", s.match)
+	}
+	return res
+}
+
+// deleteUserMessages deletes messages of the user in the scope, or the whole history if the scope is not set,
+// and returns the number of deleted messages
+func deleteUserMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass, scope deleteScope) (int, error) {
+	if !scope.isSet() {
+		return deleteUserHistory(ctx, api, channel, user)
+	}
+	ids, err := searchUserMessages(ctx, api, channel, user, scope)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int
+	for start := 0; start < len(ids); start += messagesBatchSize {
+		end := min(start+messagesBatchSize, len(ids))
+		affected, e := api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+			Channel: channel.AsInput(),
+			ID:      ids[start:end],
+		})
+		if e != nil {
+			return deleted, e
+		}
+		deleted += affected.PtsCount
+	}
+	return deleted, nil
+}
+
+// searchUserMessages returns IDs of the user messages in the scope
+func searchUserMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass, scope deleteScope) ([]int, error) {
+	var ids []int
+	err := forEachUserMessage(ctx, api, channel, user, scope, func(m tg.MessageClass) {
+		ids = append(ids, m.GetID())
+	})
+	return ids, err
+}
+
+// forEachUserMessage calls fn for every user message in the scope, going through all the search result pages
+func forEachUserMessage(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass, scope deleteScope, fn func(tg.MessageClass)) error {
+	req := &tg.MessagesSearchRequest{
+		FromID: user,
+		Peer:   channel.AsInputPeer(),
+		Filter: &tg.InputMessagesFilterEmpty{},
+		Limit:  messagesBatchSize,
+	}
+	if !scope.from.IsZero() {
+		req.MinDate = int(scope.from.Unix())
+	}
+	if !scope.to.IsZero() {
+		req.MaxDate = int(scope.to.Unix())
+	}
+	for {
+		messages, err := api.MessagesSearch(ctx, req)
+		if err != nil {
+			return err
+		}
+		page := messagesFromResult(messages)
+		if len(page) == 0 {
+			return nil
+		}
+		for _, m := range page {
+			if scope.match != nil {
+				if msg, ok := m.(*tg.Message); !ok || !scope.match.MatchString(msg.Message) {
+					continue
+				}
+			}
+			fn(m)
+		}
+		// results are sorted from the newest to the oldest, next page starts before the last returned message
+		req.OffsetID = page[len(page)-1].GetID()
+		if len(page) < messagesBatchSize {
+			return nil
+		}
+	}
+}
+
+// messagesFromResult returns messages from any kind of messages search result
+func messagesFromResult(messages tg.MessagesMessagesClass) []tg.MessageClass {
+	switch v := messages.(type) {
+	case *tg.MessagesMessages:
+		return v.Messages
+	case *tg.MessagesMessagesSlice:
+		return v.Messages
+	case *tg.MessagesChannelMessages:
+		return v.Messages
+	}
+	return nil
+}
+
+// deleteUserHistory deletes all messages of the user in the channel, repeating the call until Telegram reports
+// zero offset as required by the API, and returns the number of deleted messages
+func deleteUserHistory(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass) (int, error) {
+	var deleted int
+	for i := 0; i < maxHistoryDeleteCalls; i++ {
+		affected, err := api.ChannelsDeleteParticipantHistory(ctx, &tg.ChannelsDeleteParticipantHistoryRequest{
+			Channel:     channel.AsInput(),
+			Participant: user,
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += affected.PtsCount
+		if affected.Offset == 0 {
+			return deleted, nil
+		}
+		log.Printf("[DEBUG] %d messages deleted so far, continuing from offset %d", deleted, affected.Offset)
+	}
+	return deleted, fmt.Errorf("history deletion didn't finish after %d calls", maxHistoryDeleteCalls)
+}
+
+// countRemainingMessages returns number of the user messages in the scope, or in the whole history if the scope is not set
+func countRemainingMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass, scope deleteScope) (int, error) {
+	if !scope.isSet() {
+		return countUserMessages(ctx, api, channel, user)
+	}
+	ids, err := searchUserMessages(ctx, api, channel, user, scope)
+	return len(ids), err
+}
+
+// countUserMessages returns number of messages of the user in the channel found by the search
+func countUserMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass) (int, error) {
+	messages, err := api.MessagesSearch(ctx, &tg.MessagesSearchRequest{
+		FromID: user,
+		Peer:   channel.AsInputPeer(),
+		Filter: &tg.InputMessagesFilterEmpty{},
+		Limit:  1,
+	})
+	if err != nil {
+		return 0, err
+	}
+	switch v := messages.(type) {
+	case *tg.MessagesMessages:
+		return len(v.Messages), nil
+	case *tg.MessagesMessagesSlice:
+		return v.Count, nil
+	case *tg.MessagesChannelMessages:
+		return v.Count, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// content rules of the first messages inspection
+const (
+	ruleLinks    = "links"
+	ruleInvites  = "invites"
+	ruleForwards = "forwards"
+	ruleButtons  = "buttons"
+	ruleKeywords = "keywords"
+)
+
+// inviteLinkRe matches Telegram invite links to private groups and channels
+var inviteLinkRe = regexp.MustCompile(`(?i)(?:t|telegram)\.(?:me|dog)/(?:\+|joinchat/)`)
+
+// inspectParams are the settings of the first messages inspection
+type inspectParams struct {
+	messages int           // number of the first messages of the new member to inspect, 0 disables the inspection
+	window   time.Duration // members who joined within that time are new
+	rules    []string      // content rules to apply, keywords rule is applied if there are keywords
+	keywords []string      // case-insensitive keywords
+}
+
+// inspectedUser is the state of the first messages inspection for a single user
+type inspectedUser struct {
+	joined   time.Time
+	seen     time.Time // last message or the join, to forget the inactive users
+	ignored  bool      // user is an admin or is not in the channel
+	messages int       // messages inspected so far
+}
+
+// inspector checks the first messages of the new members against the content rules
+type inspector struct {
+	api     *tg.Client
+	channel *tg.Channel
+	params  inspectParams
+
+	mu    sync.Mutex
+	users map[int64]*inspectedUser
+}
+
+// newInspector creates inspector of the channel messages
+func newInspector(api *tg.Client, channel *tg.Channel, params inspectParams) *inspector {
+	for i, k := range params.keywords {
+		params.keywords[i] = strings.ToLower(k)
+	}
+	return &inspector{api: api, channel: channel, params: params, users: map[int64]*inspectedUser{}}
+}
+
+// rules returns the content rules applied by the inspector
+func (i *inspector) rules() []string {
+	rules := append([]string{}, i.params.rules...)
+	if len(i.params.keywords) > 0 {
+		rules = append(rules, ruleKeywords)
+	}
+	return rules
+}
+
+// joined records the join time of the user seen joining, so it doesn't have to be retrieved
+func (i *inspector) joined(userID int64, date time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.users[userID] = &inspectedUser{joined: date, seen: time.Now()}
+}
+
+// prune forgets users who were not seen within the inspection window,
+// their join time is retrieved again if they write after that
+func (i *inspector) prune(now time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for id, u := range i.users {
+		if now.Sub(u.seen) > i.params.window {
+			delete(i.users, id)
+		}
+	}
+}
+
+// inspect returns the content rule matched by the message if it's one of the first messages of the new member,
+// or empty string otherwise. Join time of the users who joined before the watch started is retrieved once.
+func (i *inspector) inspect(ctx context.Context, userID int64, msg *tg.Message) string {
+	i.mu.Lock()
+	u, ok := i.users[userID]
+	i.mu.Unlock()
+	if !ok {
+		u = &inspectedUser{}
+		info, err := getParticipant(ctx, i.api, i.channel, &tg.InputPeerUserFromMessage{
+			Peer:   i.channel.AsInputPeer(),
+			MsgID:  msg.ID,
+			UserID: userID,
+		})
+		switch {
+		case err != nil:
+			log.Printf("[WARN] Error retrieving join time of the user %d, not inspecting their messages: %v", userID, err)
+			u.ignored = true
+		case info.state != participantMember && info.state != participantRestricted:
+			u.ignored = true // admins and users who are not in the channel anymore
+		default:
+			u.joined = info.joined
+		}
+		i.mu.Lock()
+		i.users[userID] = u
+		i.mu.Unlock()
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	u.seen = time.Now()
+	if u.ignored || time.Since(u.joined) > i.params.window || u.messages >= i.params.messages {
+		return ""
+	}
+	u.messages++
+	return i.match(msg)
+}
+
+// match returns the first content rule matched by the message, or empty string if none
+func (i *inspector) match(msg *tg.Message) string {
+	for _, rule := range i.params.rules {
+		var matched bool
+		switch rule {
+		case ruleLinks:
+			matched = len(messageURLs(msg)) > 0
+		case ruleInvites:
+			matched = inviteLinkRe.MatchString(msg.Message)
+			for _, u := range messageURLs(msg) {
+				matched = matched || inviteLinkRe.MatchString(u)
+			}
+		case ruleForwards:
+			_, matched = msg.GetFwdFrom()
+		case ruleButtons:
+			_, matched = msg.ReplyMarkup.(*tg.ReplyInlineMarkup)
+		}
+		if matched {
+			return rule
+		}
+	}
+	text := strings.ToLower(msg.Message)
+	for _, k := range i.params.keywords {
+		if strings.Contains(text, k) {
+			return ruleKeywords
+		}
+	}
+	return ""
+}
+
+// messageURLs returns links of the message: from the text, hidden behind the text and the link preview
+func messageURLs(msg *tg.Message) []string {
+	var urls []string
+	text := utf16.Encode([]rune(msg.Message)) // entity offsets are in UTF-16 code units
+	for _, entity := range msg.Entities {
+		switch e := entity.(type) {
+		case *tg.MessageEntityURL:
+			if e.Offset >= 0 && e.Offset+e.Length <= len(text) {
+				urls = append(urls, string(utf16.Decode(text[e.Offset:e.Offset+e.Length])))
+			}
+		case *tg.MessageEntityTextURL:
+			urls = append(urls, e.URL)
+		}
+	}
+	if media, ok := msg.Media.(*tg.MessageMediaWebPage); ok {
+		if page, ok := media.Webpage.(*tg.WebPage); ok {
+			urls = append(urls, page.URL)
+		}
+	}
+	return urls
+}
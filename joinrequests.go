@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// columnDecision is the column of the join requests list filled by the reviewer
+const columnDecision = "decision"
+
+// join request decisions
+const (
+	decisionApprove = "approve"
+	decisionDecline = "decline"
+)
+
+// listJoinRequests retrieves pending join requests of the channel and writes them to the file for the review,
+// in the ban list format with the request date as joined time, the request message and the empty decision column
+func listJoinRequests(ctx context.Context, api *tg.Client, channel *tg.Channel, adminPhone string) {
+	var requests []banUserInfo
+	offsetDate, offsetUser := 0, tg.InputUserClass(&tg.InputUserEmpty{})
+	for {
+		if ctx.Err() != nil {
+			log.Printf("[INFO] Canceled, writing %d join requests retrieved so far", len(requests))
+			break
+		}
+		res, err := api.MessagesGetChatInviteImporters(ctx, &tg.MessagesGetChatInviteImportersRequest{
+			Requested:  true,
+			Peer:       channel.AsInputPeer(),
+			OffsetDate: offsetDate,
+			OffsetUser: offsetUser,
+			Limit:      requestLimit,
+		})
+		if err != nil {
+			log.Printf("[ERROR] Error retrieving join requests: %v", err)
+			break
+		}
+		for _, importer := range res.Importers {
+			user := findUser(res.Users, importer.UserID)
+			if user == nil {
+				log.Printf("[WARN] User %d who requested to join is not found in the response", importer.UserID)
+				continue
+			}
+			info := userInfoFromUser(user)
+			info.joined = time.Unix(int64(importer.Date), 0)
+			info.message = importer.About
+			requests = append(requests, info)
+			offsetDate, offsetUser = importer.Date, user.AsInput()
+		}
+		log.Printf("[INFO] Retrieved %d/%d join requests", len(requests), res.Count)
+		if len(res.Importers) < requestLimit {
+			break
+		}
+	}
+	if len(requests) == 0 {
+		log.Printf("[INFO] No pending join requests found")
+		return
+	}
+
+	meta := banListMeta{
+		channelID:      channel.ID,
+		channelTitle:   channel.Title,
+		created:        time.Now(),
+		revision:       revision,
+		adminPhoneHash: phoneHash(adminPhone),
+	}
+	fileName := fmt.Sprintf("./ban/%s.requests.csv", time.Now().Format("2006-01-02T15-04-05"))
+	if err := writeJoinRequests(requests, meta, fileName); err != nil {
+		log.Printf("[ERROR] Error writing join requests to file: %v", err)
+		return
+	}
+	log.Printf("[INFO] %d join requests are written to %s", len(requests), fileName)
+	log.Printf("[INFO] Please review, set %q column to %s or %s, and run same command with the following flag:",
+		columnDecision, decisionApprove, decisionDecline)
+	log.Printf("[INFO] --join-requests-apply %s", fileName)
+}
+
+// writeJoinRequests writes join requests to tab-separated csv file in the ban list format with the decision column
+func writeJoinRequests(requests []banUserInfo, meta banListMeta, fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %w", fileName, err)
+	}
+	defer func() {
+		if e := file.Close(); e != nil {
+			log.Printf("[ERROR] Error closing file %s: %v", fileName, e)
+		}
+	}()
+	if err = meta.write(file); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	writer.Comma = '\t'
+	if err = writer.Write(append(append([]string{}, banListColumns...), columnDecision)); err != nil {
+		return fmt.Errorf("error writing row to csv: %w", err)
+	}
+	for _, r := range requests {
+		if err = writer.Write(append(userRecord(r), "")); err != nil {
+			return fmt.Errorf("error writing row to csv: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// applyJoinRequests approves or declines join requests according to the decisions in the reviewed file,
+// requests without the decision are left pending
+func applyJoinRequests(ctx context.Context, api *tg.Client, channel *tg.Channel, filePath string, yes bool) {
+	list, err := readBanList(filePath)
+	if err != nil {
+		log.Printf("[ERROR] error reading join requests from the file %s: %v", filePath, err)
+		return
+	}
+	if err = list.meta.checkChannel(channel.ID); err != nil {
+		log.Printf("[ERROR] %v, refusing to apply the decisions", err)
+		return
+	}
+	list.logRejected(filePath)
+	if len(list.rejected) > 0 {
+		log.Printf("[ERROR] Refusing to apply the decisions with rejected rows in the file, fix them first")
+		return
+	}
+	decisionIdx := -1
+	for i, name := range list.header {
+		if normalizeColumnName(name) == columnDecision {
+			decisionIdx = i
+			break
+		}
+	}
+	if decisionIdx < 0 {
+		log.Printf("[ERROR] %s has no %q column in the header %v", filePath, columnDecision, list.header)
+		return
+	}
+
+	var decided []banListEntry
+	var approved []bool
+	counts := map[string]int{}
+	for _, entry := range list.entries {
+		decision := ""
+		if decisionIdx < len(entry.record) {
+			decision = strings.ToLower(strings.TrimSpace(entry.record[decisionIdx]))
+		}
+		switch decision {
+		case decisionApprove, decisionDecline:
+			decided = append(decided, entry)
+			approved = append(approved, decision == decisionApprove)
+			counts[decision]++
+		case "":
+			counts["pending"]++
+		default:
+			log.Printf("[WARN] line %d: unknown decision %q for the user %d, expected %s or %s, leaving the request pending",
+				entry.line, decision, entry.user.userID, decisionApprove, decisionDecline)
+			counts["pending"]++
+		}
+	}
+	log.Printf("[INFO] %d join requests to approve, %d to decline, %d left pending",
+		counts[decisionApprove], counts[decisionDecline], counts["pending"])
+	if len(decided) == 0 {
+		return
+	}
+	if !yes && !confirmBan(ctx, os.Stdin, os.Stdout, channel, len(decided)) {
+		log.Printf("[INFO] Not confirmed, no join requests were processed")
+		return
+	}
+
+	var done, gone, failed int
+	for i, entry := range decided {
+		if ctx.Err() != nil {
+			log.Printf("[INFO] Canceled after processing %d/%d join requests", i, len(decided))
+			break
+		}
+		_, err = api.MessagesHideChatJoinRequest(ctx, &tg.MessagesHideChatJoinRequestRequest{
+			Approved: approved[i],
+			Peer:     channel.AsInputPeer(),
+			UserID:   &tg.InputUser{UserID: entry.user.userID, AccessHash: entry.user.accessHash},
+		})
+		switch {
+		case tgerr.Is(err, "HIDE_REQUESTER_MISSING"):
+			log.Printf("[INFO] Join request of the user %d is already processed or withdrawn", entry.user.userID)
+			gone++
+		case err != nil:
+			log.Printf("[ERROR] Error processing join request of the user %d: %v", entry.user.userID, err)
+			failed++
+		case approved[i]:
+			log.Printf("[INFO] Join request of the user %d is approved", entry.user.userID)
+			done++
+		default:
+			log.Printf("[INFO] Join request of the user %d is declined", entry.user.userID)
+			done++
+		}
+	}
+	log.Printf("[INFO] Join requests processed: %d done, %d already gone, %d failed", done, gone, failed)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// journalEntry is a single automatic decision of the watch mode
+type journalEntry struct {
+	trigger string // what caused the decision, like join burst or rule match
+	user    banUserInfo
+	action  string // what was done to the user
+	result  string // outcome of the action
+}
+
+// journal is the append-only log of the automatic decisions made in watch mode, kept per channel,
+// so every action taken while nobody was watching could be reviewed later
+type journal struct {
+	path   string
+	mu     sync.Mutex
+	file   *os.File
+	writer *csv.Writer
+}
+
+// journalFilePath returns path of the journal for given channel
+func journalFilePath(channelID int64) string {
+	return fmt.Sprintf("./ban/%d.journal.csv", channelID)
+}
+
+// openJournal opens the journal for appending new records, creating it if needed
+func openJournal(path string) (*journal, error) {
+	_, statErr := os.Stat(path)
+	isNew := errors.Is(statErr, os.ErrNotExist)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal %s: %w", path, err)
+	}
+	j := &journal{path: path, file: f, writer: csv.NewWriter(f)}
+	j.writer.Comma = '\t'
+	if isNew {
+		if err = j.write([]string{"time", "trigger", "userID", "username", "name", "action", "result"}); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// record appends the entry to the journal, errors are logged as the journal must not stop the watch
+func (j *journal) record(e journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	err := j.write([]string{
+		time.Now().Format(time.RFC3339),
+		e.trigger,
+		strconv.FormatInt(e.user.userID, 10),
+		e.user.username,
+		strings.ReplaceAll(strings.TrimSpace(e.user.firstName+" "+e.user.lastName), "\t", " "),
+		e.action,
+		strings.ReplaceAll(e.result, "\t", " "),
+	})
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+}
+
+// write appends the record to the file and flushes it
+func (j *journal) write(record []string) error {
+	if err := j.writer.Write(record); err != nil {
+		return fmt.Errorf("error writing journal %s: %w", j.path, err)
+	}
+	j.writer.Flush()
+	if err := j.writer.Error(); err != nil {
+		return fmt.Errorf("error writing journal %s: %w", j.path, err)
+	}
+	return nil
+}
+
+// Close closes the underlying file
+func (j *journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.writer.Flush()
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("error closing journal %s: %w", j.path, err)
+	}
+	return nil
+}
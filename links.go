@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+var (
+	// t.me/c/<channel id>/<message id>, with optional thread id in the middle
+	privateMessageLinkRe = regexp.MustCompile(`^(?:https?://)?(?:t|telegram)\.me/c/(\d+)/(?:\d+/)?(\d+)/?(?:\?.*)?$`)
+	// t.me/<channel username>/<message id>, with optional thread id in the middle
+	publicMessageLinkRe = regexp.MustCompile(`^(?:https?://)?(?:t|telegram)\.me/([A-Za-z0-9_]{4,})/(?:\d+/)?(\d+)/?(?:\?.*)?$`)
+	// t.me/<username>
+	userLinkRe = regexp.MustCompile(`^(?:https?://)?(?:t|telegram)\.me/([A-Za-z0-9_]{4,})/?(?:\?.*)?$`)
+	// @username
+	usernameRe = regexp.MustCompile(`^@([A-Za-z0-9_]{4,})$`)
+)
+
+// unresolvedLink is the line of the links list which couldn't be turned into a user
+type unresolvedLink struct {
+	line   int
+	link   string
+	reason string
+}
+
+// resolveLinksToBanList reads the list of usernames, t.me user links and message links, one per line,
+// resolves them to users and writes the ban list for the channel next to the links list, returning the path to it.
+// Lines which couldn't be resolved are reported and written next to the links list as well.
+// The ban list written by the previous run is reused, so the restarted run resumes instead of starting over.
+func resolveLinksToBanList(ctx context.Context, api *tg.Client, channel *tg.Channel, linksPath, adminPhone string) (string, error) {
+	fileName := strings.TrimSuffix(linksPath, ".txt") + ".users.csv"
+	if _, err := os.Stat(fileName); err == nil {
+		log.Printf("[INFO] Using ban list %s resolved from %s by the previous run, delete it to resolve the links again", fileName, linksPath)
+		return fileName, nil
+	}
+
+	f, err := os.Open(linksPath)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %w", linksPath, err)
+	}
+	defer f.Close()
+
+	var users []banUserInfo
+	var unresolved []unresolvedLink
+	seen := map[int64]bool{}
+	scanner := bufio.NewScanner(f)
+	var line int
+	for scanner.Scan() {
+		line++
+		link, _, _ := strings.Cut(scanner.Text(), "\t#") // comments, like in the unresolved lines file
+		link = strings.TrimSpace(link)
+		if link == "" || strings.HasPrefix(link, "#") {
+			continue
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		user, e := resolveLink(ctx, api, channel, link)
+		if e != nil {
+			log.Printf("[WARN] line %d: can't resolve %s: %v", line, link, e)
+			unresolved = append(unresolved, unresolvedLink{line: line, link: link, reason: e.Error()})
+			continue
+		}
+		if seen[user.userID] {
+			continue
+		}
+		seen[user.userID] = true
+		log.Printf("[INFO] line %d: %s resolved to the user %d", line, link, user.userID)
+		users = append(users, user)
+	}
+	if err = scanner.Err(); err != nil {
+		return "", fmt.Errorf("error reading %s: %w", linksPath, err)
+	}
+
+	if len(unresolved) > 0 {
+		unresolvedPath := strings.TrimSuffix(linksPath, ".txt") + ".unresolved.txt"
+		if e := writeUnresolvedLinks(unresolved, unresolvedPath); e != nil {
+			log.Printf("[ERROR] %v", e)
+		} else {
+			log.Printf("[WARN] %d lines couldn't be resolved, they are written to %s", len(unresolved), unresolvedPath)
+		}
+	}
+	if len(users) == 0 {
+		return "", fmt.Errorf("no users resolved from %s", linksPath)
+	}
+
+	meta := banListMeta{
+		channelID:      channel.ID,
+		channelTitle:   channel.Title,
+		created:        time.Now(),
+		revision:       revision,
+		adminPhoneHash: phoneHash(adminPhone),
+	}
+	if err = writeUsersToFile(users, meta, fileName); err != nil {
+		return "", err
+	}
+	log.Printf("[INFO] %d users resolved from %s, ban list is written to %s", len(users), linksPath, fileName)
+	return fileName, nil
+}
+
+// parsedLink is the entry of the links list: either the username or the message link
+type parsedLink struct {
+	username        string // set for @username and t.me/username
+	channelID       int64  // set for t.me/c/<channel id>/<message id>
+	channelUsername string // set for t.me/<channel username>/<message id>
+	messageID       int
+}
+
+// parseLink parses the username, t.me link or the message link without resolving it
+func parseLink(link string) (parsedLink, error) {
+	if inviteLinkRe.MatchString(link) {
+		return parsedLink{}, fmt.Errorf("invite link, not a user")
+	}
+	if m := privateMessageLinkRe.FindStringSubmatch(link); m != nil {
+		channelID, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return parsedLink{}, fmt.Errorf("invalid channel id %q: %w", m[1], err)
+		}
+		msgID, err := strconv.Atoi(m[2])
+		if err != nil {
+			return parsedLink{}, fmt.Errorf("invalid message id %q: %w", m[2], err)
+		}
+		return parsedLink{channelID: channelID, messageID: msgID}, nil
+	}
+	if m := publicMessageLinkRe.FindStringSubmatch(link); m != nil {
+		msgID, err := strconv.Atoi(m[2])
+		if err != nil {
+			return parsedLink{}, fmt.Errorf("invalid message id %q: %w", m[2], err)
+		}
+		return parsedLink{channelUsername: m[1], messageID: msgID}, nil
+	}
+	username := ""
+	if m := userLinkRe.FindStringSubmatch(link); m != nil {
+		username = m[1]
+	} else if m := usernameRe.FindStringSubmatch(link); m != nil {
+		username = m[1]
+	}
+	switch {
+	case strings.EqualFold(username, "joinchat"):
+		return parsedLink{}, fmt.Errorf("invite link, not a user")
+	case username != "":
+		return parsedLink{username: username}, nil
+	}
+	return parsedLink{}, fmt.Errorf("unknown format, expected @username, t.me/username or a message link")
+}
+
+// resolveLink returns the user referenced by the username, t.me link or the message link
+func resolveLink(ctx context.Context, api *tg.Client, channel *tg.Channel, link string) (banUserInfo, error) {
+	parsed, err := parseLink(link)
+	if err != nil {
+		return banUserInfo{}, err
+	}
+	switch {
+	case parsed.username != "":
+		return resolveUsername(ctx, api, parsed.username)
+	case parsed.channelID != 0 && parsed.channelID != channel.ID:
+		return banUserInfo{}, fmt.Errorf("message link points to the channel %d, not %d", parsed.channelID, channel.ID)
+	case parsed.channelUsername != "" && !strings.EqualFold(parsed.channelUsername, channel.Username):
+		return banUserInfo{}, fmt.Errorf("message link points to @%s, not to the channel", parsed.channelUsername)
+	}
+	return resolveMessageAuthor(ctx, api, channel, parsed.messageID)
+}
+
+// resolveUsername returns the user with given username
+func resolveUsername(ctx context.Context, api *tg.Client, username string) (banUserInfo, error) {
+	resolved, err := api.ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{Username: username})
+	if err != nil {
+		return banUserInfo{}, fmt.Errorf("error resolving username: %w", err)
+	}
+	peer, ok := resolved.Peer.(*tg.PeerUser)
+	if !ok {
+		return banUserInfo{}, fmt.Errorf("@%s is not a user", username)
+	}
+	for _, u := range resolved.Users {
+		if user, ok := u.(*tg.User); ok && user.ID == peer.UserID {
+			return userInfoFromUser(user), nil
+		}
+	}
+	return banUserInfo{}, fmt.Errorf("user @%s not found in the response", username)
+}
+
+// resolveMessageAuthor returns the author of the message in the channel, along with the message text
+func resolveMessageAuthor(ctx context.Context, api *tg.Client, channel *tg.Channel, msgID int) (banUserInfo, error) {
+	result, err := api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+		Channel: channel.AsInput(),
+		ID:      []tg.InputMessageClass{&tg.InputMessageID{ID: msgID}},
+	})
+	if err != nil {
+		return banUserInfo{}, fmt.Errorf("error retrieving message %d: %w", msgID, err)
+	}
+	messages := messagesFromResult(result)
+	if len(messages) != 1 {
+		return banUserInfo{}, fmt.Errorf("message %d not found", msgID)
+	}
+	msg, ok := messages[0].(*tg.Message)
+	if !ok {
+		return banUserInfo{}, fmt.Errorf("message %d is deleted or is a service message", msgID)
+	}
+	from, ok := msg.GetFromID()
+	if !ok {
+		return banUserInfo{}, fmt.Errorf("message %d has no author, likely it's sent on behalf of the channel", msgID)
+	}
+	peer, ok := from.(*tg.PeerUser)
+	if !ok {
+		return banUserInfo{}, fmt.Errorf("message %d is sent on behalf of a channel, not a user", msgID)
+	}
+	for _, u := range usersFromResult(result) {
+		if user, ok := u.(*tg.User); ok && user.ID == peer.UserID {
+			info := userInfoFromUser(user)
+			info.message, info.messageID = msg.Message, msgID
+			return info, nil
+		}
+	}
+	return banUserInfo{}, fmt.Errorf("author of the message %d not found in the response", msgID)
+}
+
+// usersFromResult returns users from any kind of messages result
+func usersFromResult(messages tg.MessagesMessagesClass) []tg.UserClass {
+	switch v := messages.(type) {
+	case *tg.MessagesMessages:
+		return v.Users
+	case *tg.MessagesMessagesSlice:
+		return v.Users
+	case *tg.MessagesChannelMessages:
+		return v.Users
+	}
+	return nil
+}
+
+// userInfoFromUser returns ban information about given user
+func userInfoFromUser(user *tg.User) banUserInfo {
+	return banUserInfo{
+		userID:     user.ID,
+		accessHash: user.AccessHash,
+		username:   user.Username,
+		firstName:  user.FirstName,
+		lastName:   user.LastName,
+	}
+}
+
+// writeUnresolvedLinks writes unresolved lines along with the reasons to the text file
+func writeUnresolvedLinks(unresolved []unresolvedLink, fileName string) error {
+	var sb strings.Builder
+	for _, u := range unresolved {
+		sb.WriteString(fmt.Sprintf("%s\t# line %d: %s\n", u.link, u.line, u.reason))
+	}
+	if err := os.WriteFile(fileName, []byte(sb.String()), 0o600); err != nil {
+		return fmt.Errorf("error writing %s: %w", fileName, err)
+	}
+	return nil
+}
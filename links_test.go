@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLink(t *testing.T) {
+	tests := []struct {
+		link     string
+		expected parsedLink
+		err      string
+	}{
+		{link: "@spammer", expected: parsedLink{username: "spammer"}},
+		{link: "@spam_bot_2000", expected: parsedLink{username: "spam_bot_2000"}},
+		{link: "t.me/spammer", expected: parsedLink{username: "spammer"}},
+		{link: "https://t.me/spammer/", expected: parsedLink{username: "spammer"}},
+		{link: "http://telegram.me/spammer?start=1", expected: parsedLink{username: "spammer"}},
+		{link: "https://t.me/c/1234567/42", expected: parsedLink{channelID: 1234567, messageID: 42}},
+		{link: "t.me/c/1234567/100/42", expected: parsedLink{channelID: 1234567, messageID: 42}},
+		{link: "https://t.me/c/1234567/42?single", expected: parsedLink{channelID: 1234567, messageID: 42}},
+		{link: "https://t.me/somechannel/42", expected: parsedLink{channelUsername: "somechannel", messageID: 42}},
+		{link: "https://telegram.me/somechannel/7/42/", expected: parsedLink{channelUsername: "somechannel", messageID: 42}},
+		{link: "https://t.me/somechannel/42?comment=1", expected: parsedLink{channelUsername: "somechannel", messageID: 42}},
+		{link: "https://t.me/+AbCdEf123", err: "invite link"},
+		{link: "t.me/joinchat/AbCdEf123", err: "invite link"},
+		{link: "https://t.me/joinchat", err: "invite link"},
+		{link: "spammer", err: "unknown format"},
+		{link: "@abc", err: "unknown format"},
+		{link: "https://example.com/spammer", err: "unknown format"},
+		{link: "https://t.me/c/1234567", err: "unknown format"},
+		{link: "t.me/spam-mer", err: "unknown format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			got, err := parseLink(tt.link)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %+v, %v", tt.err, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// lockdownParams are the settings applied to the channel during the lockdown
+type lockdownParams struct {
+	slowMode int           // slow mode interval in seconds
+	readOnly bool          // forbid members to send anything, not only media and links
+	duration time.Duration // restore the settings after that time, 0 keeps the lockdown until restored manually
+}
+
+// lockdownSnapshot is the channel settings before the lockdown, restored after it
+type lockdownSnapshot struct {
+	ChannelID           int64               `json:"channel_id"`
+	Created             time.Time           `json:"created"`
+	JoinRequest         bool                `json:"join_request"`
+	SlowModeSeconds     int                 `json:"slow_mode_seconds"`
+	DefaultBannedRights tg.ChatBannedRights `json:"default_banned_rights"`
+}
+
+// lockdownFilePath returns path of the settings snapshot for given channel
+func lockdownFilePath(channelID int64) string {
+	return fmt.Sprintf("./ban/%d.lockdown.json", channelID)
+}
+
+// lockdownChannel snapshots the channel settings, then enables join requests, slow mode and tighter default rights.
+// With the duration set, waits for it and restores the snapshot.
+func lockdownChannel(ctx context.Context, api *tg.Client, channel *tg.Channel, params lockdownParams) error {
+	path := lockdownFilePath(channel.ID)
+	if _, err := os.Stat(path); err == nil {
+		// the snapshot of the locked down channel would make the lockdown permanent
+		return fmt.Errorf("channel is locked down already, settings snapshot %s exists, restore them first", path)
+	}
+	snapshot, err := takeLockdownSnapshot(ctx, api, channel)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding settings snapshot: %w", err)
+	}
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("error writing settings snapshot %s: %w", path, err)
+	}
+	log.Printf("[INFO] Channel settings are saved to %s: join requests %t, slow mode %ds, default rights: %v",
+		path, snapshot.JoinRequest, snapshot.SlowModeSeconds, takenRights(snapshot.DefaultBannedRights))
+
+	rights := lockdownRights(snapshot.DefaultBannedRights, params.readOnly)
+	err = applyChannelSettings(ctx, api, channel, true, params.slowMode, rights)
+	if err != nil {
+		log.Printf("[WARN] Channel is locked down partially: %v", err)
+	} else {
+		log.Printf("[INFO] Channel is locked down: join requests enabled, slow mode %ds, default rights: %v", params.slowMode, takenRights(rights))
+	}
+
+	if params.duration <= 0 {
+		log.Printf("[INFO] Run the same command with --lockdown-restore instead of --lockdown to restore the settings")
+		return nil
+	}
+	log.Printf("[INFO] Settings will be restored in %s, at %s", params.duration, time.Now().Add(params.duration).Format(time.RFC3339))
+	select {
+	case <-ctx.Done():
+		log.Printf("[WARN] Interrupted, the channel stays locked down, run the same command with --lockdown-restore instead of --lockdown to restore the settings")
+		return nil
+	case <-time.After(params.duration):
+	}
+	return restoreChannel(ctx, api, channel)
+}
+
+// restoreChannel returns the channel settings to the snapshot taken before the lockdown
+func restoreChannel(ctx context.Context, api *tg.Client, channel *tg.Channel) error {
+	path := lockdownFilePath(channel.ID)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no settings snapshot %s, channel is not locked down", path)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading settings snapshot %s: %w", path, err)
+	}
+	var snapshot lockdownSnapshot
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("error parsing settings snapshot %s: %w", path, err)
+	}
+	if snapshot.ChannelID != channel.ID {
+		return fmt.Errorf("settings snapshot %s is taken for channel %d, not %d", path, snapshot.ChannelID, channel.ID)
+	}
+	err = applyChannelSettings(ctx, api, channel, snapshot.JoinRequest, snapshot.SlowModeSeconds, snapshot.DefaultBannedRights)
+	if err != nil {
+		// the snapshot is kept, so the restore could be repeated
+		return fmt.Errorf("error restoring settings from %s: %w", path, err)
+	}
+	if err = os.Remove(path); err != nil {
+		log.Printf("[WARN] Error removing settings snapshot %s: %v", path, err)
+	}
+	log.Printf("[INFO] Channel settings are restored to the ones saved %s", snapshot.Created.Format(time.RFC3339))
+	return nil
+}
+
+// takeLockdownSnapshot returns the current settings of the channel
+func takeLockdownSnapshot(ctx context.Context, api *tg.Client, channel *tg.Channel) (lockdownSnapshot, error) {
+	full, err := api.ChannelsGetFullChannel(ctx, channel.AsInput())
+	if err != nil {
+		return lockdownSnapshot{}, fmt.Errorf("error retrieving channel settings: %w", err)
+	}
+	snapshot := lockdownSnapshot{ChannelID: channel.ID, Created: time.Now()}
+	if channelFull, ok := full.FullChat.(*tg.ChannelFull); ok {
+		snapshot.SlowModeSeconds, _ = channelFull.GetSlowmodeSeconds()
+	}
+	// the channel from the list could be outdated, so the one from the full response is used
+	for _, c := range full.Chats {
+		if ch, ok := c.(*tg.Channel); ok && ch.ID == channel.ID {
+			channel = ch
+		}
+	}
+	snapshot.JoinRequest = channel.JoinRequest
+	snapshot.DefaultBannedRights, _ = channel.GetDefaultBannedRights()
+	return snapshot, nil
+}
+
+// lockdownRights returns the default rights with media, links, stickers and the rest of non-text content forbidden,
+// along with plain text as well in read-only mode
+func lockdownRights(current tg.ChatBannedRights, readOnly bool) tg.ChatBannedRights {
+	rights := current
+	rights.SendMedia = true
+	rights.SendStickers = true
+	rights.SendGifs = true
+	rights.SendGames = true
+	rights.SendInline = true
+	rights.EmbedLinks = true
+	rights.SendPolls = true
+	rights.ChangeInfo = true
+	rights.InviteUsers = true
+	rights.PinMessages = true
+	rights.SendPhotos = true
+	rights.SendVideos = true
+	rights.SendRoundvideos = true
+	rights.SendAudios = true
+	rights.SendVoices = true
+	rights.SendDocs = true
+	if readOnly {
+		rights.SendMessages = true
+		rights.SendPlain = true
+	}
+	rights.UntilDate = 0
+	return rights
+}
+
+// applyChannelSettings sets join requests, slow mode and default rights of the channel,
+// trying all of them even if some fail
+func applyChannelSettings(ctx context.Context, api *tg.Client, channel *tg.Channel, joinRequest bool, slowMode int, rights tg.ChatBannedRights) error {
+	var errs []error
+	_, err := api.ChannelsToggleJoinRequest(ctx, &tg.ChannelsToggleJoinRequestRequest{Channel: channel.AsInput(), Enabled: joinRequest})
+	if err != nil && !tgerr.Is(err, "CHAT_NOT_MODIFIED") {
+		errs = append(errs, fmt.Errorf("error setting join requests: %w", err))
+	}
+	_, err = api.ChannelsToggleSlowMode(ctx, &tg.ChannelsToggleSlowModeRequest{Channel: channel.AsInput(), Seconds: slowMode})
+	if err != nil && !tgerr.Is(err, "CHAT_NOT_MODIFIED") {
+		errs = append(errs, fmt.Errorf("error setting slow mode: %w", err))
+	}
+	_, err = api.MessagesEditChatDefaultBannedRights(ctx, &tg.MessagesEditChatDefaultBannedRightsRequest{
+		Peer:         channel.AsInputPeer(),
+		BannedRights: rights,
+	})
+	if err != nil && !tgerr.Is(err, "CHAT_NOT_MODIFIED") {
+		errs = append(errs, fmt.Errorf("error setting default rights: %w", err))
+	}
+	return errors.Join(errs...)
+}
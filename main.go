@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -15,6 +17,7 @@ import (
 	"github.com/gotd/contrib/middleware/floodwait"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/updates"
 	"github.com/gotd/td/tg"
 	"github.com/jessevdk/go-flags"
 	"go.uber.org/zap"
@@ -34,6 +37,61 @@ type options struct {
 	BanSearchLimit       int           `long:"ban-search-limit" description:"limit of users to check for a ban, 0 is unlimited"`
 	SearchIgnoreMessages bool          `long:"search-ignore-messages" description:"do not retrieve messages when searching for users to ban"`
 	BanAndKickFilePath   string        `long:"ban-and-kick-filepath" description:"set this option to a path to a text file with users clean up their messages, ban and kick them"`
+	BanLinksFilePath     string        `long:"ban-links-filepath" description:"set this option to a path to a text file with @usernames, t.me links and message links to resolve them to users and ban them"`
+	BanIgnoreChannel     bool          `long:"ban-ignore-channel-mismatch" description:"ban users even if the ban list was created for another channel"`
+	BanAcceptRejected    bool          `long:"ban-accept-rejected" description:"skip rows of the ban list which didn't pass validation instead of refusing to ban"`
+	BanRetryFailed       bool          `long:"ban-retry-failed" description:"process users which failed with retryable errors in the previous run of the same ban list again"`
+	BanAction            string        `long:"ban-action" choice:"ban" choice:"restrict" choice:"none" default:"ban" description:"action applied to the users from the ban list after their messages are deleted"`
+	BanRestrictDuration  time.Duration `long:"ban-restrict-duration" description:"duration of the restriction for restrict ban-action, 0 is forever"`
+	DeleteFromTime       string        `long:"delete-from-time" description:"delete only messages sent after this time, dd-mm-yyThh:mm:ss format, in your timezone"`
+	DeleteToTime         string        `long:"delete-to-time" description:"delete only messages sent before this time, dd-mm-yyThh:mm:ss format, in your timezone"`
+	DeleteMatch          string        `long:"delete-match" description:"delete only messages matching this regular expression"`
+	BanArchive           bool          `long:"ban-archive" description:"archive messages and profiles of the users to ./ban/archive before deleting them"`
+	BanArchiveMedia      bool          `long:"ban-archive-media" description:"download media files of the messages to the archive as well"`
+	BanReportSpam        bool          `long:"ban-report-spam" description:"report messages of the users as spam to Telegram before deleting them"`
+	BanReportPeer        bool          `long:"ban-report-peer" description:"report the users themselves as spammers to Telegram"`
+	BanMaxMembership     time.Duration `long:"ban-max-membership" description:"users who joined the channel earlier than that long ago are established members, 0 is no limit"`
+	BanMembershipAction  string        `long:"ban-max-membership-action" choice:"skip" choice:"abort" default:"skip" description:"skip established members, or abort the run on the first one"`
+	BanMaxUsers          int           `long:"ban-max-users" description:"abort if more users than that are to be processed in a single run, 0 is no limit"`
+	BanMaxShare          float64       `long:"ban-max-share" description:"abort if more than that percent of the channel members are to be processed in a single run, 0 is no limit"`
+	BanProtectMessages   int           `long:"ban-protect-messages" description:"abort if a user to process has more messages than that, 0 is no limit"`
+	BanForce             bool          `long:"ban-force" description:"ignore the safety limits"`
+	BanDryRun            bool          `long:"ban-dry-run" description:"check every user of the ban list and write the plan of the run without banning anyone"`
+	Yes                  bool          `long:"yes" description:"do not ask for the confirmation before banning, for automation"`
+	BanVerify            bool          `long:"ban-verify" description:"verify that processed users are banned and have no messages left after the run"`
+	Watch                bool          `long:"watch" description:"watch the channel for new joiners and messages and write ban candidates to ./ban, until stopped"`
+	WatchWindow          time.Duration `long:"watch-window" default:"1h" description:"how long the recent joiners are tracked in watch mode"`
+	WatchBurstJoins      int           `long:"watch-burst-joins" default:"20" description:"that many joins within watch-burst-window is a join burst, 0 disables burst detection"`
+	WatchBurstWindow     time.Duration `long:"watch-burst-window" default:"1m" description:"window for the join burst detection"`
+	WatchMatch           string        `long:"watch-match" description:"regular expression, recent joiners with messages matching it are ban candidates"`
+	WatchProfileMatch    string        `long:"watch-profile-match" description:"regular expression, joiners and members changing their profile with name or username matching it are ban candidates"`
+	WatchAction          string        `long:"watch-action" choice:"none" choice:"restrict" choice:"ban" default:"none" description:"action applied automatically to the ban candidates found in watch mode, after their messages are deleted"`
+	WatchMaxActions      int           `long:"watch-max-actions" default:"300" description:"maximum number of users acted upon automatically within watch-cooldown, 0 is no limit"`
+	WatchCooldown        time.Duration `long:"watch-cooldown" default:"1h" description:"once watch-max-actions users are acted upon, automatic actions stop for the rest of that time"`
+	WatchQuarantine      time.Duration `long:"watch-quarantine" description:"forbid new joiners to send media and links for that long in watch mode, 0 disables the quarantine"`
+	WatchInspectMessages int           `long:"watch-inspect-messages" description:"inspect that many first messages of the new members in watch mode, banning them on a rule match, 0 disables the inspection"`
+	WatchInspectWindow   time.Duration `long:"watch-inspect-window" default:"24h" description:"members who joined within that time are new for the first messages inspection"`
+	WatchInspectRule     []string      `long:"watch-inspect-rule" choice:"links" choice:"invites" choice:"forwards" choice:"buttons" default:"links" default:"invites" default:"forwards" default:"buttons" description:"content rule of the first messages inspection, could be repeated"`
+	WatchInspectKeyword  []string      `long:"watch-inspect-keyword" description:"case-insensitive keyword of the first messages inspection, could be repeated"`
+	WatchFloodMessages   int           `long:"watch-flood-messages" description:"that many messages of a user within watch-flood-window is a flood in watch mode, 0 disables the check"`
+	WatchFloodDuplicates int           `long:"watch-flood-duplicates" description:"that many identical messages of a user within watch-flood-window is a flood in watch mode, 0 disables the check"`
+	WatchFloodWindow     time.Duration `long:"watch-flood-window" default:"1m" description:"sliding window of the flood detection"`
+	WatchFloodStep       []string      `long:"watch-flood-step" choice:"delete" choice:"mute" choice:"ban" default:"delete" default:"mute" default:"ban" description:"response to the consecutive floods of the same user, in order, could be repeated, the last one is repeated for the further floods"`
+	WatchFloodMute       time.Duration `long:"watch-flood-mute" default:"10m" description:"duration of the mute flood response"`
+	WatchStrikes         bool          `long:"watch-strikes" description:"respond to the first message rules and the floods with the strike ladder instead of the immediate action"`
+	StrikeStep           []string      `long:"strike-step" choice:"warn" choice:"mute" choice:"ban" default:"warn" default:"mute" default:"mute" default:"ban" description:"step of the strike ladder for every next strike, could be repeated, the last one is repeated for the further strikes"`
+	StrikeMute           time.Duration `long:"strike-mute" default:"1h" description:"duration of the first mute of the strike ladder, doubled with every next one"`
+	StrikeDecay          time.Duration `long:"strike-decay" default:"168h" description:"one strike is forgiven after that time without violations, 0 never forgives"`
+	StrikeWarning        string        `long:"strike-warning" default:"Please follow the chat rules, further violations will lead to a mute and a ban." description:"text of the warning message"`
+	QuarantineLift       []int64       `long:"quarantine-lift" description:"lift the quarantine of the user with given ID early, could be repeated"`
+	QuarantineExempt     []int64       `long:"quarantine-exempt" description:"never quarantine the user with given ID, lifting the current quarantine, could be repeated"`
+	Lockdown             bool          `long:"lockdown" description:"save the channel settings, then enable join requests, slow mode and forbid members to send media and links"`
+	LockdownRestore      bool          `long:"lockdown-restore" description:"restore the channel settings saved before the lockdown"`
+	LockdownSlowMode     int           `long:"lockdown-slow-mode" choice:"0" choice:"10" choice:"30" choice:"60" choice:"300" choice:"900" choice:"3600" default:"60" description:"slow mode interval during the lockdown, in seconds"`
+	LockdownReadOnly     bool          `long:"lockdown-read-only" description:"forbid members to send anything during the lockdown, including text"`
+	LockdownDuration     time.Duration `long:"lockdown-duration" description:"restore the settings automatically after that time, 0 keeps the lockdown until --lockdown-restore"`
+	JoinRequestsList     bool          `long:"join-requests-list" description:"write pending join requests to a file in ./ban for the review"`
+	JoinRequestsApply    string        `long:"join-requests-apply" description:"set this option to a path to the reviewed join requests file to approve or decline them"`
 
 	Dbg bool `long:"dbg" description:"debug mode"`
 }
@@ -49,6 +107,9 @@ const maxWait = time.Minute * 15
 // that tick value allows ban not being painfully slow after 300 users
 const tick = time.Second
 
+// timeLayout is the format of the time options, like 31-10-22T19:30:15
+const timeLayout = "02-01-06T15:04:05"
+
 func main() {
 	var opts options
 	if _, err := flags.Parse(&opts); err != nil {
@@ -87,6 +148,13 @@ func main() {
 		SessionStorage: &telegram.FileSessionStorage{Path: fmt.Sprintf("./ban/%s.json", opts.Phone)},
 	}
 
+	// updates are handled only in watch mode, with gaps recovered by the updates manager
+	dispatcher := tg.NewUpdateDispatcher()
+	gaps := updates.New(updates.Config{Handler: dispatcher})
+	if opts.Watch {
+		telegramOptions.UpdateHandler = gaps
+	}
+
 	// logging for Telegram library
 	if opts.Dbg {
 		if logger, err := zap.NewProduction(); err == nil {
@@ -120,9 +188,60 @@ func main() {
 			return err
 		}
 
+		// lockdown case
+		if opts.Lockdown {
+			return lockdownChannel(ctx, api, channel, lockdownParams{
+				slowMode: opts.LockdownSlowMode,
+				readOnly: opts.LockdownReadOnly,
+				duration: opts.LockdownDuration,
+			})
+		}
+		if opts.LockdownRestore {
+			return restoreChannel(ctx, api, channel)
+		}
+
+		// join requests case
+		if opts.JoinRequestsList {
+			listJoinRequests(ctx, api, channel, opts.Phone)
+			return nil
+		}
+		if opts.JoinRequestsApply != "" {
+			applyJoinRequests(ctx, api, channel, opts.JoinRequestsApply, opts.Yes)
+			return nil
+		}
+
+		// quarantine management case
+		if len(opts.QuarantineLift) > 0 || len(opts.QuarantineExempt) > 0 {
+			liftQuarantines(ctx, api, channel, opts.QuarantineLift, false)
+			liftQuarantines(ctx, api, channel, opts.QuarantineExempt, true)
+			return nil
+		}
+
+		// watch mode case
+		if opts.Watch {
+			params, e := watchParamsFromOptions(opts)
+			if e != nil {
+				log.Printf("[ERROR] %v", e)
+				return nil
+			}
+			return watchChannel(ctx, client, gaps, dispatcher, channel, params)
+		}
+
 		// ban users case
-		if opts.BanAndKickFilePath != "" {
-			banAndKickUsers(ctx, api, channel, opts.BanAndKickFilePath)
+		if opts.BanAndKickFilePath != "" || opts.BanLinksFilePath != "" {
+			params, e := banParamsFromOptions(opts)
+			if e != nil {
+				log.Printf("[ERROR] %v", e)
+				return nil
+			}
+			if opts.BanLinksFilePath != "" {
+				log.Printf("[INFO] Resolving users from %s", opts.BanLinksFilePath)
+				if params.filePath, e = resolveLinksToBanList(ctx, api, channel, opts.BanLinksFilePath, opts.Phone); e != nil {
+					log.Printf("[ERROR] %v", e)
+					return nil
+				}
+			}
+			banAndKickUsers(ctx, api, channel, params)
 			return nil
 		}
 
@@ -132,7 +251,7 @@ func main() {
 			banTo = time.Unix(opts.BanToTimestamp, 0)
 		}
 		if opts.BanToTime != "" {
-			banTo, err = time.ParseInLocation("02-01-06T15:04:05", opts.BanToTime, time.Local)
+			banTo, err = time.ParseInLocation(timeLayout, opts.BanToTime, time.Local)
 			if err != nil {
 				log.Printf("[ERROR] can't parse ban-to-time: %v", err)
 				return nil
@@ -152,6 +271,7 @@ func main() {
 			offset:         opts.BanSearchOffset,
 			limit:          opts.BanSearchLimit,
 			ignoreMessages: opts.SearchIgnoreMessages,
+			adminPhone:     opts.Phone,
 		})
 
 		return nil
@@ -165,6 +285,127 @@ func main() {
 	}
 }
 
+// watchParamsFromOptions returns watch mode parameters from the command line options
+func watchParamsFromOptions(opts options) (watchParams, error) {
+	ban, err := banParamsFromOptions(opts)
+	if err != nil {
+		return watchParams{}, err
+	}
+	params := watchParams{
+		window:      opts.WatchWindow,
+		burstJoins:  opts.WatchBurstJoins,
+		burstWindow: opts.WatchBurstWindow,
+		adminPhone:  opts.Phone,
+		quarantine:  opts.WatchQuarantine,
+		inspect: inspectParams{
+			messages: opts.WatchInspectMessages,
+			window:   opts.WatchInspectWindow,
+			rules:    opts.WatchInspectRule,
+			keywords: opts.WatchInspectKeyword,
+		},
+		flood: floodParams{
+			messages:   opts.WatchFloodMessages,
+			duplicates: opts.WatchFloodDuplicates,
+			window:     opts.WatchFloodWindow,
+			steps:      opts.WatchFloodStep,
+			mute:       opts.WatchFloodMute,
+			forget:     opts.WatchWindow,
+		},
+		strikes: strikeParams{
+			enabled: opts.WatchStrikes,
+			steps:   opts.StrikeStep,
+			mute:    opts.StrikeMute,
+			decay:   opts.StrikeDecay,
+			warning: opts.StrikeWarning,
+		},
+		action:     banAction(opts.WatchAction),
+		maxActions: opts.WatchMaxActions,
+		cooldown:   opts.WatchCooldown,
+		ban:        ban,
+	}
+	if opts.WatchMatch != "" {
+		if params.match, err = regexp.Compile(opts.WatchMatch); err != nil {
+			return watchParams{}, fmt.Errorf("can't parse watch-match: %w", err)
+		}
+	}
+	if opts.WatchProfileMatch != "" {
+		if params.profileMatch, err = regexp.Compile(opts.WatchProfileMatch); err != nil {
+			return watchParams{}, fmt.Errorf("can't parse watch-profile-match: %w", err)
+		}
+	}
+	if params.quarantine != 0 && (params.quarantine < minRestriction || params.quarantine > maxRestriction) {
+		return watchParams{}, fmt.Errorf("watch-quarantine must be between %s and %s, otherwise Telegram restricts forever", minRestriction, maxRestriction)
+	}
+	if params.flood.enabled() && (params.flood.mute < minRestriction || params.flood.mute > maxRestriction) {
+		return watchParams{}, fmt.Errorf("watch-flood-mute must be between %s and %s, otherwise Telegram restricts forever", minRestriction, maxRestriction)
+	}
+	if params.strikes.enabled && (params.strikes.mute < minRestriction || params.strikes.mute > maxRestriction) {
+		return watchParams{}, fmt.Errorf("strike-mute must be between %s and %s, otherwise Telegram restricts forever", minRestriction, maxRestriction)
+	}
+	if params.burstWindow > params.window {
+		return watchParams{}, fmt.Errorf("watch-burst-window %s is longer than watch-window %s", params.burstWindow, params.window)
+	}
+	return params, nil
+}
+
+// banParamsFromOptions returns ban mode parameters from the command line options
+func banParamsFromOptions(opts options) (banParams, error) {
+	params := banParams{
+		filePath:              opts.BanAndKickFilePath,
+		ignoreChannelMismatch: opts.BanIgnoreChannel,
+		acceptRejected:        opts.BanAcceptRejected,
+		retryFailed:           opts.BanRetryFailed,
+		action:                banAction(opts.BanAction),
+		restrictFor:           opts.BanRestrictDuration,
+		archiveMedia:          opts.BanArchiveMedia,
+		reportSpam:            opts.BanReportSpam,
+		reportPeer:            opts.BanReportPeer,
+		verify:                opts.BanVerify,
+		dryRun:                opts.BanDryRun,
+		yes:                   opts.Yes,
+		adminPhone:            opts.Phone,
+		peerCachePath:         fmt.Sprintf("./ban/%s.peers.json", opts.Phone),
+		limits: safetyLimits{
+			maxUsers:    opts.BanMaxUsers,
+			maxShare:    opts.BanMaxShare,
+			maxMessages: opts.BanProtectMessages,
+			force:       opts.BanForce,
+		},
+	}
+	// the single membership age cutoff either skips established members or is a safety limit aborting the run
+	if opts.BanMembershipAction == "abort" {
+		params.limits.maxAge = opts.BanMaxMembership
+	} else {
+		params.maxMembership = opts.BanMaxMembership
+	}
+	if opts.BanArchive || opts.BanArchiveMedia {
+		params.archiveDir = "./ban/archive"
+	}
+	var err error
+	if opts.DeleteFromTime != "" {
+		if params.scope.from, err = time.ParseInLocation(timeLayout, opts.DeleteFromTime, time.Local); err != nil {
+			return banParams{}, fmt.Errorf("can't parse delete-from-time: %w", err)
+		}
+	}
+	if opts.DeleteToTime != "" {
+		if params.scope.to, err = time.ParseInLocation(timeLayout, opts.DeleteToTime, time.Local); err != nil {
+			return banParams{}, fmt.Errorf("can't parse delete-to-time: %w", err)
+		}
+	}
+	if !params.scope.from.IsZero() && !params.scope.to.IsZero() && params.scope.from.After(params.scope.to) {
+		return banParams{}, fmt.Errorf("delete-from-time %s is later than delete-to-time %s", opts.DeleteFromTime, opts.DeleteToTime)
+	}
+	if opts.DeleteMatch != "" {
+		if params.scope.match, err = regexp.Compile(opts.DeleteMatch); err != nil {
+			return banParams{}, fmt.Errorf("can't parse delete-match: %w", err)
+		}
+	}
+	if params.restrictFor != 0 && (params.restrictFor < minRestriction || params.restrictFor > maxRestriction) {
+		return banParams{}, fmt.Errorf("ban-restrict-duration must be 0 or between %s and %s, otherwise Telegram restricts forever", minRestriction, maxRestriction)
+	}
+	return params, nil
+}
+
 // ensureDirectoryExists ensures the directory exists, creates it if it doesn't,
 // and returns error in case of problem creating it or if specified path is not a directory
 func ensureDirectoryExists(dir string) error {
@@ -225,8 +466,25 @@ func getChannel(ctx context.Context, api *tg.Client, channelID int64) (*tg.Chann
 	return chat, nil
 }
 
-// writeUsersToFile writes users to tab-separated csv file
-func writeUsersToFile(users []banUserInfo, fileName string) error {
+// banListColumns is the header of the ban list written by the search
+var banListColumns = []string{"joined", "userID", "access_hash", "username", "firstName", "lastName", "message", "message_id"}
+
+// userRecord returns the ban list row of the user, matching banListColumns
+func userRecord(user banUserInfo) []string {
+	return []string{
+		formatJoined(user.joined),                     // joined
+		fmt.Sprintf("%d", user.userID),                // userID
+		fmt.Sprintf("%d", user.accessHash),            // accessHash
+		user.username,                                 // username
+		strings.ReplaceAll(user.firstName, "\t", " "), // firstName
+		strings.ReplaceAll(user.lastName, "\t", " "),  // lastName
+		strings.ReplaceAll(user.message, "\t", " "),   // message
+		formatMessageID(user.messageID),               // messageID
+	}
+}
+
+// writeUsersToFile writes users to tab-separated csv file, preceded by the ban list metadata
+func writeUsersToFile(users []banUserInfo, meta banListMeta, fileName string) error {
 	file, err := os.Create(fileName)
 	if err != nil {
 		log.Printf("[ERROR] Error creating file %s: %v", fileName, err)
@@ -241,18 +499,13 @@ func writeUsersToFile(users []banUserInfo, fileName string) error {
 		}()
 	}
 
-	data := [][]string{{"joined", "userID", "access_hash", "username", "firstName", "lastName", "message"}}
+	if err = meta.write(file); err != nil {
+		return err
+	}
 
+	data := [][]string{banListColumns}
 	for _, user := range users {
-		data = append(data, []string{
-			user.joined.Format(time.RFC3339),              // joined
-			fmt.Sprintf("%d", user.userID),                // userID
-			fmt.Sprintf("%d", user.accessHash),            // accessHash
-			user.username,                                 // username
-			strings.ReplaceAll(user.firstName, "\t", " "), // firstName
-			strings.ReplaceAll(user.lastName, "\t", " "),  // lastName
-			strings.ReplaceAll(user.message, "\t", " "),   // message
-		})
+		data = append(data, userRecord(user))
 	}
 
 	writer := csv.NewWriter(file)
@@ -267,6 +520,22 @@ func writeUsersToFile(users []banUserInfo, fileName string) error {
 	return nil
 }
 
+// formatJoined returns joined time in RFC3339 format, or empty string if it's unknown
+func formatJoined(joined time.Time) string {
+	if joined.IsZero() {
+		return ""
+	}
+	return joined.Format(time.RFC3339)
+}
+
+// formatMessageID returns message ID as a string, or empty string if there is no message
+func formatMessageID(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
+
 func setupLog(dbg bool) {
 	if dbg {
 		log.Setup(log.Debug, log.CallerFile, log.CallerFunc, log.Msec, log.LevelBraces)
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// participantState is the current state of the user in the channel
+type participantState string
+
+const (
+	participantMember     participantState = "member"
+	participantAdmin      participantState = "admin"
+	participantCreator    participantState = "creator"
+	participantSelf       participantState = "self"
+	participantBanned     participantState = "banned"     // kicked and can't view messages
+	participantRestricted participantState = "restricted" // in the channel, but with some rights taken away
+	participantLeft       participantState = "left"
+	participantAbsent     participantState = "absent" // not in the channel and never was banned
+)
+
+// participantInfo is the current state of the user in the channel along with the details of it
+type participantInfo struct {
+	state  participantState
+	joined time.Time           // zero if unknown, like for the restricted users
+	rights tg.ChatBannedRights // set only for banned and restricted users
+}
+
+// getParticipant retrieves the current state of the user in the channel
+func getParticipant(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass) (participantInfo, error) {
+	res, err := api.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+		Channel:     channel.AsInput(),
+		Participant: user,
+	})
+	if tgerr.Is(err, "USER_NOT_PARTICIPANT") {
+		return participantInfo{state: participantAbsent}, nil
+	}
+	if err != nil {
+		return participantInfo{}, err
+	}
+	switch p := res.Participant.(type) {
+	case *tg.ChannelParticipant:
+		return participantInfo{state: participantMember, joined: time.Unix(int64(p.Date), 0)}, nil
+	case *tg.ChannelParticipantSelf:
+		return participantInfo{state: participantSelf, joined: time.Unix(int64(p.Date), 0)}, nil
+	case *tg.ChannelParticipantCreator:
+		return participantInfo{state: participantCreator}, nil
+	case *tg.ChannelParticipantAdmin:
+		return participantInfo{state: participantAdmin, joined: time.Unix(int64(p.Date), 0)}, nil
+	case *tg.ChannelParticipantBanned:
+		// date of the restriction, not of the join, so the join time stays unknown
+		info := participantInfo{state: participantRestricted, rights: p.BannedRights}
+		if p.BannedRights.ViewMessages {
+			info.state = participantBanned
+		}
+		return info, nil
+	case *tg.ChannelParticipantLeft:
+		return participantInfo{state: participantLeft}, nil
+	}
+	return participantInfo{state: participantAbsent}, nil
+}
+
+// rightsCovered returns true if all the rights taken away in expected are taken away in actual as well
+func rightsCovered(actual, expected tg.ChatBannedRights) bool {
+	pairs := [][2]bool{
+		{actual.ViewMessages, expected.ViewMessages},
+		{actual.SendMessages, expected.SendMessages},
+		{actual.SendMedia, expected.SendMedia},
+		{actual.SendStickers, expected.SendStickers},
+		{actual.SendGifs, expected.SendGifs},
+		{actual.SendGames, expected.SendGames},
+		{actual.SendInline, expected.SendInline},
+		{actual.EmbedLinks, expected.EmbedLinks},
+		{actual.SendPolls, expected.SendPolls},
+		{actual.ChangeInfo, expected.ChangeInfo},
+		{actual.InviteUsers, expected.InviteUsers},
+		{actual.PinMessages, expected.PinMessages},
+	}
+	for _, p := range pairs {
+		if p[1] && !p[0] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// changed access hashes are written to disk in batches of that size, and the rest at the end of the run
+const peerCacheBatch = 100
+
+// peerCache is the persistent map of user IDs to access hashes valid for the logged-in account.
+// Access hashes are tied to the account which fetched them, so the cache is stored per phone next to the session.
+type peerCache struct {
+	path    string
+	hashes  map[int64]int64
+	changed int // access hashes changed since the last write
+}
+
+// loadPeerCache reads the peer cache from the file, missing file results in empty cache
+func loadPeerCache(path string) (*peerCache, error) {
+	c := &peerCache{path: path, hashes: map[int64]int64{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading peer cache %s: %w", path, err)
+	}
+	var stored map[string]int64
+	if err = json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("error parsing peer cache %s: %w", path, err)
+	}
+	for k, v := range stored {
+		if id, e := strconv.ParseInt(k, 10, 64); e == nil {
+			c.hashes[id] = v
+		}
+	}
+	return c, nil
+}
+
+// get returns cached access hash of the user
+func (c *peerCache) get(userID int64) (int64, bool) {
+	hash, ok := c.hashes[userID]
+	return hash, ok
+}
+
+// set stores access hash of the user, writing the cache to disk once enough changes are collected
+func (c *peerCache) set(userID, accessHash int64) error {
+	if hash, ok := c.hashes[userID]; ok && hash == accessHash {
+		return nil
+	}
+	c.hashes[userID] = accessHash
+	if c.changed++; c.changed < peerCacheBatch {
+		return nil
+	}
+	return c.write()
+}
+
+// write writes the cache to disk if there are unwritten changes
+func (c *peerCache) write() error {
+	if c.changed == 0 {
+		return nil
+	}
+	stored := make(map[string]int64, len(c.hashes))
+	for k, v := range c.hashes {
+		stored[strconv.FormatInt(k, 10)] = v
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("error encoding peer cache: %w", err)
+	}
+	if err = os.WriteFile(c.path, data, 0o600); err != nil {
+		return fmt.Errorf("error writing peer cache %s: %w", c.path, err)
+	}
+	c.changed = 0
+	return nil
+}
+
+// peerResolver finds access hashes valid for the logged-in account for the users from the ban lists
+// produced by other accounts
+type peerResolver struct {
+	api     *tg.Client
+	channel *tg.Channel
+	cache   *peerCache
+	users   map[int64]banUserInfo // users of the ban list, used to look them up
+}
+
+// newPeerResolver creates resolver for the users of the ban list, with the cache stored at given path
+func newPeerResolver(api *tg.Client, channel *tg.Channel, entries []banListEntry, cachePath string) (*peerResolver, error) {
+	cache, err := loadPeerCache(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[int64]banUserInfo, len(entries))
+	for _, entry := range entries {
+		users[entry.user.userID] = entry.user
+	}
+	return &peerResolver{api: api, channel: channel, cache: cache, users: users}, nil
+}
+
+// prepare replaces access hashes of the ban list entries with the cached ones. When the list is produced by another
+// account, the users missing in the cache are resolved, as the stored access hashes are not valid for this account.
+func (r *peerResolver) prepare(ctx context.Context, entries []banListEntry, foreign bool) {
+	var cached, resolved, failed int
+	for i := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		user := &entries[i].user
+		if hash, ok := r.cache.get(user.userID); ok {
+			user.accessHash = hash
+			r.users[user.userID] = *user
+			cached++
+			continue
+		}
+		if !foreign {
+			continue
+		}
+		peer, err := r.resolve(ctx, *user)
+		if err != nil {
+			log.Printf("[WARN] Can't resolve the user %d, stored access hash is used: %v", user.userID, err)
+			failed++
+			continue
+		}
+		user.accessHash = peer.AccessHash
+		r.users[user.userID] = *user
+		resolved++
+	}
+	if foreign {
+		log.Printf("[INFO] Access hashes: %d users from the peer cache, %d resolved, %d not resolved", cached, resolved, failed)
+	}
+}
+
+// inputPeer returns the peer of the ban list entry with the latest access hash known for the user
+func (r *peerResolver) inputPeer(entry banListEntry) *tg.InputPeerUser {
+	if user, ok := r.users[entry.user.userID]; ok {
+		return &tg.InputPeerUser{UserID: user.userID, AccessHash: user.accessHash}
+	}
+	return entry.inputPeer()
+}
+
+// getParticipant retrieves the current state of the user in the channel. If the access hash of the user is invalid
+// for this account, the user is resolved again and the peer is updated in place before the retry.
+func (r *peerResolver) getParticipant(ctx context.Context, user *tg.InputPeerUser) (participantInfo, error) {
+	info, err := getParticipant(ctx, r.api, r.channel, user)
+	if err != nil && classifyError(err) == failureInvalidPeer {
+		stored, ok := r.users[user.UserID]
+		if !ok {
+			stored = banUserInfo{userID: user.UserID}
+		}
+		stored.accessHash = user.AccessHash
+		peer, e := r.resolve(ctx, stored)
+		if e != nil {
+			return participantInfo{}, fmt.Errorf("%w, and re-resolving failed: %w", err, e)
+		}
+		log.Printf("[INFO] Access hash of the user %d is invalid for this account, re-resolved it", user.UserID)
+		user.AccessHash = peer.AccessHash
+		stored.accessHash = peer.AccessHash
+		r.users[user.UserID] = stored
+		info, err = getParticipant(ctx, r.api, r.channel, user)
+	}
+	if err == nil {
+		r.remember(user)
+	}
+	return info, err
+}
+
+// resolve returns the peer of the user with the access hash valid for the logged-in account,
+// trying the cache, the message sent by the user, the username and the search over channel members by name.
+// The cached access hash equal to the one of the user is considered stale.
+func (r *peerResolver) resolve(ctx context.Context, user banUserInfo) (*tg.InputPeerUser, error) {
+	if hash, ok := r.cache.get(user.userID); ok && hash != user.accessHash {
+		return &tg.InputPeerUser{UserID: user.userID, AccessHash: hash}, nil
+	}
+
+	var errs []error
+	resolvers := []struct {
+		name string
+		fn   func() (*tg.User, error)
+	}{
+		{"message", func() (*tg.User, error) { return r.byMessage(ctx, user) }},
+		{"username", func() (*tg.User, error) { return r.byUsername(ctx, user) }},
+		{"name search", func() (*tg.User, error) { return r.byNameSearch(ctx, user) }},
+	}
+	for _, res := range resolvers {
+		u, err := res.fn()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("by %s: %w", res.name, err))
+			continue
+		}
+		if u == nil {
+			continue
+		}
+		if e := r.cache.set(u.ID, u.AccessHash); e != nil {
+			log.Printf("[WARN] %v", e)
+		}
+		log.Printf("[DEBUG] access hash of the user %d resolved by %s", user.userID, res.name)
+		return &tg.InputPeerUser{UserID: u.ID, AccessHash: u.AccessHash}, nil
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("user %d not found, no message, username or name to look up", user.userID)
+	}
+	return nil, fmt.Errorf("user %d not found: %w", user.userID, errors.Join(errs...))
+}
+
+// remember stores the access hash fetched by the logged-in account
+func (r *peerResolver) remember(user *tg.InputPeerUser) {
+	if err := r.cache.set(user.UserID, user.AccessHash); err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+}
+
+// flush writes the access hashes not written to the cache file yet, should be called at the end of the run
+func (r *peerResolver) flush() {
+	if err := r.cache.write(); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+}
+
+// byMessage finds the user through the message they sent to the channel
+func (r *peerResolver) byMessage(ctx context.Context, user banUserInfo) (*tg.User, error) {
+	if user.messageID == 0 {
+		return nil, nil
+	}
+	res, err := r.api.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+		Channel: r.channel.AsInput(),
+		Participant: &tg.InputPeerUserFromMessage{
+			Peer:   r.channel.AsInputPeer(),
+			MsgID:  user.messageID,
+			UserID: user.userID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return findUser(res.Users, user.userID), nil
+}
+
+// byUsername finds the user by their username
+func (r *peerResolver) byUsername(ctx context.Context, user banUserInfo) (*tg.User, error) {
+	if user.username == "" {
+		return nil, nil
+	}
+	res, err := r.api.ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{Username: user.username})
+	if err != nil {
+		return nil, err
+	}
+	return findUser(res.Users, user.userID), nil
+}
+
+// byNameSearch finds the user among the channel members by their name
+func (r *peerResolver) byNameSearch(ctx context.Context, user banUserInfo) (*tg.User, error) {
+	q := strings.TrimSpace(user.firstName + " " + user.lastName)
+	if q == "" {
+		return nil, nil
+	}
+	res, err := r.api.ChannelsGetParticipants(ctx, &tg.ChannelsGetParticipantsRequest{
+		Channel: r.channel.AsInput(),
+		Filter:  &tg.ChannelParticipantsSearch{Q: q},
+		Limit:   requestLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if participants, ok := res.(*tg.ChannelsChannelParticipants); ok {
+		return findUser(participants.Users, user.userID), nil
+	}
+	return nil, nil
+}
+
+// findUser returns the user with given ID from the list
+func findUser(users []tg.UserClass, userID int64) *tg.User {
+	for _, u := range users {
+		if user, ok := u.(*tg.User); ok && user.ID == userID {
+			return user
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// planEntry is the action planned for a single user of the ban list
+type planEntry struct {
+	user     banUserInfo
+	state    participantState
+	joined   time.Time
+	messages int    // messages in the deletion scope
+	action   string // what will be done to the user
+	calls    int    // expected number of API calls
+	bans     int    // expected number of ban calls, which are subject to the flood limits
+}
+
+// planBanRun resolves every user to process and checks their current state without making any destructive call,
+// and writes the plan with per-user actions, expected API calls and the run duration next to the ban list
+func planBanRun(ctx context.Context, api *tg.Client, channel *tg.Channel, list banList, users []*tg.InputPeerUser, params banParams) {
+	breaches, err := params.limits.checkRun(ctx, api, channel, len(users))
+	if err != nil {
+		log.Printf("[WARN] Error checking safety limits: %v", err)
+	}
+	for _, b := range breaches {
+		log.Printf("[WARN] Safety limit would be breached, the run would be aborted: %s", b)
+	}
+
+	entries := map[int64]banListEntry{}
+	for _, entry := range list.entries {
+		entries[entry.user.userID] = entry
+	}
+
+	plan := make([]planEntry, 0, len(users))
+	actions := map[string]int{}
+	var calls, bans int
+	for i, user := range users {
+		if ctx.Err() != nil {
+			log.Printf("[INFO] Planning canceled after checking %d/%d users", i, len(users))
+			return
+		}
+		p := planUser(ctx, api, channel, user, params)
+		p.user = entries[user.UserID].user
+		plan = append(plan, p)
+		actions[strings.SplitN(p.action, ":", 2)[0]]++
+		calls += p.calls
+		bans += p.bans
+		log.Printf("[INFO] Planned #%d/%d, user %d: %s", i+1, len(users), user.UserID, p.action)
+	}
+
+	log.Printf("[INFO] Plan for %d users: %d API calls expected, estimated duration is %s",
+		len(plan), calls, estimateDuration(calls, bans).Round(time.Second))
+	actionNames := make([]string, 0, len(actions))
+	for action := range actions {
+		actionNames = append(actionNames, action)
+	}
+	sort.Strings(actionNames)
+	for _, action := range actionNames {
+		log.Printf("[INFO] %s: %d users", action, actions[action])
+	}
+	planPath := strings.TrimSuffix(params.filePath, ".csv") + ".plan.csv"
+	if err = writePlan(plan, planPath); err != nil {
+		log.Printf("[ERROR] Error writing the plan: %v", err)
+		return
+	}
+	log.Printf("[INFO] Plan is written to %s, no changes were made", planPath)
+}
+
+// planUser returns the action planned for the user, using only read-only API calls
+func planUser(ctx context.Context, api *tg.Client, channel *tg.Channel, user *tg.InputPeerUser, params banParams) planEntry {
+	var p planEntry
+	info, err := params.resolver.getParticipant(ctx, user)
+	if err != nil {
+		p.action = fmt.Sprintf("fail: error retrieving participant, %s: %v", classifyError(err), err)
+		return p
+	}
+	p.state, p.joined = info.state, info.joined
+	p.calls = 1 // participant check
+	if reason := skipReason(info, params.maxMembership); reason != "" {
+		p.action = "skip: " + reason
+		return p
+	}
+	breach, err := params.limits.checkUser(ctx, api, channel, user, info)
+	if err != nil {
+		p.action = fmt.Sprintf("fail: error checking safety limits: %v", err)
+		return p
+	}
+	if breach != "" {
+		p.action = "abort: " + breach
+		return p
+	}
+	if params.limits.maxMessages > 0 && !params.limits.force {
+		p.calls++
+	}
+
+	p.messages, err = countRemainingMessages(ctx, api, channel, user, params.scope)
+	if err != nil {
+		p.action = fmt.Sprintf("fail: error counting messages: %v", err)
+		return p
+	}
+	pages := p.messages/messagesBatchSize + 1
+
+	var steps []string
+	if params.archiveDir != "" {
+		steps = append(steps, "archive")
+		p.calls += 1 + pages // profile and messages
+	}
+	if params.reportSpam || params.reportPeer {
+		steps = append(steps, "report")
+		if params.reportSpam {
+			p.calls += pages + (p.messages+messagesBatchSize-1)/messagesBatchSize
+		}
+		if params.reportPeer {
+			p.calls++
+		}
+	}
+	steps = append(steps, fmt.Sprintf("delete %d messages", p.messages))
+	if params.scope.isSet() {
+		p.calls += pages + (p.messages+messagesBatchSize-1)/messagesBatchSize
+	} else {
+		p.calls += pages // history is deleted in chunks
+	}
+	if _, ok := params.action.rights(params.restrictFor); ok {
+		steps = append(steps, string(params.action))
+		p.calls++
+		p.bans++
+	}
+	p.calls++ // remaining messages check
+	p.action = "process: " + strings.Join(steps, ", ")
+	return p
+}
+
+// writePlan writes the planned actions to tab-separated csv file
+func writePlan(plan []planEntry, fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %w", fileName, err)
+	}
+	defer func() {
+		if e := file.Close(); e != nil {
+			log.Printf("[ERROR] Error closing file %s: %v", fileName, e)
+		}
+	}()
+
+	writer := csv.NewWriter(file)
+	writer.Comma = '\t'
+	if err = writer.Write([]string{"userID", "username", "firstName", "lastName", "state", "joined", "messages", "action", "calls"}); err != nil {
+		return fmt.Errorf("error writing row to csv: %w", err)
+	}
+	for _, p := range plan {
+		joined := ""
+		if !p.joined.IsZero() {
+			joined = p.joined.Format(time.RFC3339)
+		}
+		err = writer.Write([]string{
+			strconv.FormatInt(p.user.userID, 10),
+			p.user.username,
+			strings.ReplaceAll(p.user.firstName, "\t", " "),
+			strings.ReplaceAll(p.user.lastName, "\t", " "),
+			string(p.state),
+			joined,
+			strconv.Itoa(p.messages),
+			p.action,
+			strconv.Itoa(p.calls),
+		})
+		if err != nil {
+			return fmt.Errorf("error writing row to csv: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
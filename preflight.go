@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// values used to estimate the ban run duration, taken from the observed Telegram API behavior
+const (
+	banCallsPerUser   = 3                      // participant state check, history deletion and ban
+	estimatedCallTime = 300 * time.Millisecond // single API call round trip
+	floodBanBatch     = 300                    // number of bans in a row after which Telegram API gives a cooldown
+	floodBanCooldown  = 12 * time.Minute       // cooldown after floodBanBatch bans
+)
+
+// preflightReport is the result of the checks made before the first destructive call of the ban run
+type preflightReport struct {
+	admin    string
+	problems []string
+	warnings []string
+	estimate time.Duration
+}
+
+// preflightCheck verifies that the session is alive, logged-in account has enough rights in the channel
+// for the action and there are users to ban, and estimates the duration of the run
+func preflightCheck(ctx context.Context, api *tg.Client, channel *tg.Channel, usersCount int, action banAction) preflightReport {
+	var report preflightReport
+
+	users, err := api.UsersGetUsers(ctx, []tg.InputUserClass{&tg.InputUserSelf{}})
+	switch {
+	case err != nil:
+		report.problems = append(report.problems, fmt.Sprintf("session check failed: %v", err))
+	case len(users) != 1:
+		report.problems = append(report.problems, fmt.Sprintf("session check failed: got %d users instead of the logged-in one", len(users)))
+	default:
+		if self, ok := users[0].(*tg.User); ok {
+			report.admin = fmt.Sprintf("%s %s (id %d)", self.FirstName, self.LastName, self.ID)
+			if self.Username != "" {
+				report.admin = fmt.Sprintf("@%s %s", self.Username, report.admin)
+			}
+		}
+	}
+
+	if !channel.Megagroup {
+		report.warnings = append(report.warnings, "channel is not a supergroup, history of the users can't be deleted")
+	}
+	if !channel.Creator {
+		rights, ok := channel.GetAdminRights()
+		switch {
+		case !ok:
+			report.problems = append(report.problems, "logged-in account is not an admin of the channel")
+		case action != banActionNone && !rights.BanUsers && !rights.DeleteMessages:
+			report.problems = append(report.problems, "logged-in account has neither ban users nor delete messages admin rights")
+		case action != banActionNone && !rights.BanUsers:
+			report.problems = append(report.problems, "logged-in account has no ban users admin right")
+		case !rights.DeleteMessages:
+			report.problems = append(report.problems, "logged-in account has no delete messages admin right")
+		}
+	}
+
+	if usersCount == 0 {
+		report.problems = append(report.problems, "there are no users to process in the ban list")
+	}
+
+	report.estimate = estimateDuration(usersCount*banCallsPerUser, usersCount)
+	return report
+}
+
+// estimateDuration returns expected duration of the run with given number of API calls, out of which given number are bans,
+// taking into account the cooldown Telegram gives after a number of bans in a row
+func estimateDuration(calls, bans int) time.Duration {
+	return time.Duration(calls)*estimatedCallTime + time.Duration(bans/floodBanBatch)*floodBanCooldown
+}
+
+// ok returns true if there are no problems preventing the ban run
+func (r preflightReport) ok() bool {
+	return len(r.problems) == 0
+}
+
+// log prints the report
+func (r preflightReport) log(channel *tg.Channel, usersCount int) {
+	log.Printf("[INFO] Pre-flight check for channel %d (%q), logged in as %s", channel.ID, channel.Title, r.admin)
+	log.Printf("[INFO] %d users to process, estimated duration is %s", usersCount, r.estimate.Round(time.Second))
+	for _, w := range r.warnings {
+		log.Printf("[WARN] %s", w)
+	}
+	for _, p := range r.problems {
+		log.Printf("[ERROR] %s", p)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// Telegram treats restrictions shorter than 30 seconds or longer than 366 days as permanent
+const (
+	minRestriction = time.Minute
+	maxRestriction = 366 * 24 * time.Hour
+)
+
+// quarantineEntry is the quarantined or exempted user
+type quarantineEntry struct {
+	UserID     int64     `json:"user_id"`
+	AccessHash int64     `json:"access_hash,omitempty"`
+	Until      time.Time `json:"until,omitempty"`  // end of the quarantine, zero for exempted users who were not quarantined
+	Exempt     bool      `json:"exempt,omitempty"` // never quarantine the user
+}
+
+// active returns true if the quarantine of the user is not over yet
+func (e quarantineEntry) active() bool {
+	return !e.Until.IsZero() && time.Now().Before(e.Until)
+}
+
+// quarantineState is the state file of the quarantined and exempted users of the channel.
+// Every operation reads and writes the file, so the restarted watch and the admin commands see the same state.
+type quarantineState struct {
+	path string
+	mu   sync.Mutex
+}
+
+// quarantineFilePath returns path of the quarantine state for given channel
+func quarantineFilePath(channelID int64) string {
+	return fmt.Sprintf("./ban/%d.quarantine.json", channelID)
+}
+
+// get returns the entry of the user
+func (q *quarantineState) get(userID int64) (quarantineEntry, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries, err := q.load()
+	if err != nil {
+		return quarantineEntry{}, false, err
+	}
+	entry, ok := entries[userID]
+	return entry, ok, nil
+}
+
+// update changes the state with given function, forgetting expired quarantines of users who are not exempted
+func (q *quarantineState) update(fn func(entries map[int64]quarantineEntry)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries, err := q.load()
+	if err != nil {
+		return err
+	}
+	fn(entries)
+	list := make([]quarantineEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.Exempt || e.active() {
+			list = append(list, e)
+		}
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding quarantine state: %w", err)
+	}
+	if err = os.WriteFile(q.path, data, 0o600); err != nil {
+		return fmt.Errorf("error writing quarantine state %s: %w", q.path, err)
+	}
+	return nil
+}
+
+// load reads the state file, missing file is an empty state
+func (q *quarantineState) load() (map[int64]quarantineEntry, error) {
+	entries := map[int64]quarantineEntry{}
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading quarantine state %s: %w", q.path, err)
+	}
+	var list []quarantineEntry
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing quarantine state %s: %w", q.path, err)
+	}
+	for _, e := range list {
+		entries[e.UserID] = e
+	}
+	return entries, nil
+}
+
+// quarantineRights returns rights forbidding to send media, stickers, links and the rest of non-text content until given time
+func quarantineRights(until time.Time) tg.ChatBannedRights {
+	return tg.ChatBannedRights{
+		SendMedia:       true,
+		SendStickers:    true,
+		SendGifs:        true,
+		SendGames:       true,
+		SendInline:      true,
+		EmbedLinks:      true,
+		SendPolls:       true,
+		SendPhotos:      true,
+		SendVideos:      true,
+		SendRoundvideos: true,
+		SendAudios:      true,
+		SendVoices:      true,
+		SendDocs:        true,
+		UntilDate:       int(until.Unix()),
+	}
+}
+
+// quarantineUser restricts the new joiner for the duration unless the user is exempted, and records it in the state.
+// Users who are not ordinary members anymore are left as is, so the quarantine doesn't replace their ban or restriction.
+func quarantineUser(ctx context.Context, api *tg.Client, channel *tg.Channel, state *quarantineState, user *tg.User, duration time.Duration) (string, error) {
+	entry, ok, err := state.get(user.ID)
+	if err != nil {
+		return "", err
+	}
+	if ok && entry.Exempt {
+		return "exempted", nil
+	}
+	info, err := getParticipant(ctx, api, channel, user.AsInputPeer())
+	if err != nil {
+		return "", fmt.Errorf("error retrieving participant %d: %w", user.ID, err)
+	}
+	if info.state != participantMember {
+		return fmt.Sprintf("not quarantined: user is %s", info.state), nil
+	}
+	until := time.Now().Add(duration)
+	_, err = api.ChannelsEditBanned(ctx, &tg.ChannelsEditBannedRequest{
+		Channel:      channel.AsInput(),
+		Participant:  user.AsInputPeer(),
+		BannedRights: quarantineRights(until),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error restricting the user %d: %w", user.ID, err)
+	}
+	err = state.update(func(entries map[int64]quarantineEntry) {
+		entries[user.ID] = quarantineEntry{UserID: user.ID, AccessHash: user.AccessHash, Until: until}
+	})
+	if err != nil {
+		return "", err
+	}
+	return "quarantined until " + until.Format(time.RFC3339), nil
+}
+
+// joiners waiting for the quarantine, more are not quarantined
+const quarantineQueueSize = 1000
+
+// quarantiner restricts the new joiners in the background, so the flood wait of the restrictions
+// during the join raid doesn't stall the processing of the updates
+type quarantiner struct {
+	api      *tg.Client
+	channel  *tg.Channel
+	state    *quarantineState
+	duration time.Duration
+	journal  *journal
+	queue    chan *tg.User
+	done     chan struct{} // closed when run returns
+}
+
+// newQuarantiner creates quarantiner of the channel joiners with the state kept in the channel quarantine file
+func newQuarantiner(api *tg.Client, channel *tg.Channel, duration time.Duration, j *journal) *quarantiner {
+	return &quarantiner{
+		api:      api,
+		channel:  channel,
+		state:    &quarantineState{path: quarantineFilePath(channel.ID)},
+		duration: duration,
+		journal:  j,
+		queue:    make(chan *tg.User, quarantineQueueSize),
+		done:     make(chan struct{}),
+	}
+}
+
+// enqueue schedules the quarantine of the joiner without blocking the updates processing
+func (q *quarantiner) enqueue(user *tg.User) {
+	select {
+	case q.queue <- user:
+	default:
+		log.Printf("[ERROR] Quarantine queue is full, user %d is not quarantined", user.ID)
+		q.journal.record(journalEntry{trigger: "new joiner", user: userInfoFromUser(user), action: "quarantine", result: "dropped: queue is full"})
+	}
+}
+
+// run quarantines the queued joiners until the context is canceled
+func (q *quarantiner) run(ctx context.Context) {
+	defer close(q.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case user := <-q.queue:
+			q.quarantine(ctx, user)
+		}
+	}
+}
+
+// wait blocks until run returns
+func (q *quarantiner) wait() {
+	<-q.done
+}
+
+// quarantine restricts the joiner and journals the result
+func (q *quarantiner) quarantine(ctx context.Context, user *tg.User) {
+	entry := journalEntry{trigger: "new joiner", user: userInfoFromUser(user), action: "quarantine"}
+	result, err := quarantineUser(ctx, q.api, q.channel, q.state, user, q.duration)
+	if err != nil {
+		log.Printf("[ERROR] Error quarantining the user %d: %v", user.ID, err)
+		result = "failed: " + err.Error()
+	} else {
+		log.Printf("[INFO] User %d is %s", user.ID, result)
+	}
+	entry.result = result
+	q.journal.record(entry)
+}
+
+// liftQuarantines lifts the quarantine of the users early, and with exempt set never quarantines them again.
+// Users who were banned or restricted by other means since the quarantine are left as is.
+func liftQuarantines(ctx context.Context, api *tg.Client, channel *tg.Channel, userIDs []int64, exempt bool) {
+	state := &quarantineState{path: quarantineFilePath(channel.ID)}
+	for _, userID := range userIDs {
+		if ctx.Err() != nil {
+			return
+		}
+		entry, ok, err := state.get(userID)
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			return
+		}
+		if ok && entry.active() {
+			if e := liftQuarantine(ctx, api, channel, entry); e != nil {
+				log.Printf("[ERROR] Error lifting the quarantine of the user %d: %v", userID, e)
+				continue
+			}
+			log.Printf("[INFO] Quarantine of the user %d is lifted", userID)
+		} else if !exempt {
+			log.Printf("[WARN] User %d is not quarantined", userID)
+			continue
+		}
+		err = state.update(func(entries map[int64]quarantineEntry) {
+			if !exempt {
+				delete(entries, userID)
+				return
+			}
+			entries[userID] = quarantineEntry{UserID: userID, AccessHash: entry.AccessHash, Exempt: true}
+		})
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+			continue
+		}
+		if exempt {
+			log.Printf("[INFO] User %d is exempted from the quarantine", userID)
+		}
+	}
+}
+
+// liftQuarantine removes the restriction of the quarantined user, unless it was changed since the quarantine
+func liftQuarantine(ctx context.Context, api *tg.Client, channel *tg.Channel, entry quarantineEntry) error {
+	user := &tg.InputPeerUser{UserID: entry.UserID, AccessHash: entry.AccessHash}
+	info, err := getParticipant(ctx, api, channel, user)
+	if err != nil {
+		return fmt.Errorf("error retrieving participant: %w", err)
+	}
+	if info.state != participantRestricted || !rightsCovered(quarantineRights(entry.Until), info.rights) {
+		return fmt.Errorf("user is %s with other restrictions than the quarantine, leaving as is", info.state)
+	}
+	_, err = api.ChannelsEditBanned(ctx, &tg.ChannelsEditBannedRequest{
+		Channel:      channel.AsInput(),
+		Participant:  user,
+		BannedRights: tg.ChatBannedRights{},
+	})
+	return err
+}
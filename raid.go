@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// triggers waiting for the response, more are dropped and only written as candidates
+const raidQueueSize = 1000
+
+// raidTrigger is the detected raid or rule match along with the users to act upon
+type raidTrigger struct {
+	reason string
+	action banAction
+	users  []banUserInfo
+}
+
+// raidResponder automatically applies the action of the trigger to its users.
+// To prevent it from running away, at most maxActions users are acted upon within the cooldown,
+// after that the triggers are only journaled until the cooldown passes.
+type raidResponder struct {
+	api        *tg.Client
+	channel    *tg.Channel
+	params     banParams
+	maxActions int
+	cooldown   time.Duration
+	journal    *journal
+	queue      chan raidTrigger
+	done       chan struct{} // closed when run returns
+
+	windowStart time.Time // start of the current cooldown window
+	acted       int       // users acted upon within the current cooldown window
+}
+
+// newRaidResponder creates responder deleting messages and applying the actions with given ban parameters
+func newRaidResponder(api *tg.Client, channel *tg.Channel, params banParams, maxActions int, cooldown time.Duration, j *journal) *raidResponder {
+	return &raidResponder{
+		api:        api,
+		channel:    channel,
+		params:     params,
+		maxActions: maxActions,
+		cooldown:   cooldown,
+		journal:    j,
+		queue:      make(chan raidTrigger, raidQueueSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// enqueue schedules the response to the trigger without blocking the updates processing
+func (r *raidResponder) enqueue(t raidTrigger) {
+	select {
+	case r.queue <- t:
+	default:
+		log.Printf("[ERROR] Raid response queue is full, %d users from %s trigger are not acted upon", len(t.users), t.reason)
+		for _, u := range t.users {
+			r.journal.record(journalEntry{trigger: t.reason, user: u, action: string(t.action), result: "dropped: queue is full"})
+		}
+	}
+}
+
+// run responds to the queued triggers until the context is canceled
+func (r *raidResponder) run(ctx context.Context) {
+	defer close(r.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-r.queue:
+			r.respond(ctx, t)
+		}
+	}
+}
+
+// wait blocks until run returns, so the journal and the archive are not closed under the response in progress
+func (r *raidResponder) wait() {
+	<-r.done
+}
+
+// respond applies the action to the users of the trigger, deleting their messages first, within the cooldown budget
+func (r *raidResponder) respond(ctx context.Context, t raidTrigger) {
+	log.Printf("[INFO] Responding to %s trigger: applying %q action to %d users", t.reason, t.action, len(t.users))
+	params := r.params
+	params.action = t.action
+	for _, user := range t.users {
+		if ctx.Err() != nil {
+			return
+		}
+		entry := journalEntry{trigger: t.reason, user: user, action: string(t.action)}
+		if !r.allow() {
+			log.Printf("[WARN] %d users were acted upon within %s, not acting on user %d until the cooldown passes",
+				r.acted, r.cooldown, user.userID)
+			entry.result = "not acted: cooldown"
+			r.journal.record(entry)
+			continue
+		}
+		result := banUser(ctx, r.api, r.channel, &tg.InputPeerUser{UserID: user.userID, AccessHash: user.accessHash}, params)
+		if result.breach == "" && result.skipReason == "" {
+			r.acted++
+		}
+		entry.result = describeResult(result)
+		r.journal.record(entry)
+		log.Printf("[INFO] %s trigger, user %d: %s", t.reason, user.userID, entry.result)
+	}
+}
+
+// allow returns true if one more user could be acted upon within the cooldown budget.
+// Only users who were acted upon count against the budget, skipped and protected ones don't.
+func (r *raidResponder) allow() bool {
+	if now := time.Now(); now.Sub(r.windowStart) > r.cooldown {
+		r.windowStart, r.acted = now, 0
+	}
+	return r.maxActions <= 0 || r.acted < r.maxActions
+}
+
+// describeResult returns the human-readable outcome of processing the user
+func describeResult(result banResult) string {
+	switch {
+	case result.breach != "":
+		return "not acted: " + result.breach
+	case result.skipReason != "":
+		return "skipped: " + result.skipReason
+	case result.err != nil:
+		return fmt.Sprintf("failed, %s: %v", result.class, result.err)
+	}
+	return fmt.Sprintf("done, %d messages deleted", result.deleted)
+}
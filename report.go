@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// reportUser reports messages of the user in the scope as spam to Telegram if reportSpam is set and,
+// if reportPeer is set, the user account itself. Returns human-readable result of the reporting to be stored in the status file.
+func reportUser(ctx context.Context, api *tg.Client, channel *tg.Channel, user *tg.InputPeerUser, scope deleteScope, reportSpam, reportPeer bool) string {
+	var results []string
+	if reportSpam {
+		results = append(results, reportUserMessages(ctx, api, channel, user, scope))
+	}
+	if reportPeer {
+		_, err := api.AccountReportPeer(ctx, &tg.AccountReportPeerRequest{
+			Peer:    user,
+			Reason:  &tg.InputReportReasonSpam{},
+			Message: fmt.Sprintf("spam in %s", channel.Title),
+		})
+		if err != nil {
+			log.Printf("[WARN] error reporting the user %d as spammer: %v", user.UserID, err)
+			results = append(results, fmt.Sprintf("peer: error: %v", err))
+		} else {
+			results = append(results, "peer: reported")
+		}
+	}
+	return strings.Join(results, "; ")
+}
+
+// reportUserMessages reports messages of the user in the scope as spam and returns the human-readable result
+func reportUserMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, user *tg.InputPeerUser, scope deleteScope) string {
+	ids, err := searchUserMessages(ctx, api, channel, user, scope)
+	switch {
+	case err != nil:
+		log.Printf("[WARN] error retrieving messages of the user %d to report: %v", user.UserID, err)
+		return fmt.Sprintf("spam: error retrieving messages: %v", err)
+	case len(ids) == 0:
+		return "spam: no messages"
+	}
+	reported, err := reportSpamMessages(ctx, api, channel, user, ids)
+	if err != nil {
+		log.Printf("[WARN] error reporting messages of the user %d as spam: %v", user.UserID, err)
+		return fmt.Sprintf("spam: %d/%d messages reported, error: %v", reported, len(ids), err)
+	}
+	return fmt.Sprintf("spam: %d messages reported", reported)
+}
+
+// reportSpamMessages reports given messages of the user as spam in batches, returns the number of reported messages
+func reportSpamMessages(ctx context.Context, api *tg.Client, channel *tg.Channel, user *tg.InputPeerUser, ids []int) (int, error) {
+	var reported int
+	for start := 0; start < len(ids); start += messagesBatchSize {
+		end := min(start+messagesBatchSize, len(ids))
+		if _, err := api.ChannelsReportSpam(ctx, &tg.ChannelsReportSpamRequest{
+			Channel:     channel.AsInput(),
+			Participant: user,
+			ID:          ids[start:end],
+		}); err != nil {
+			return reported, err
+		}
+		reported += end - start
+	}
+	return reported, nil
+}
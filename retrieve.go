@@ -19,6 +19,7 @@ type banUserInfo struct {
 	accessHash int64
 	joined     time.Time
 	message    string
+	messageID  int // ID of the message, which allows to resolve the user from another admin account
 	username   string
 	firstName  string
 	lastName   string
@@ -35,6 +36,7 @@ type searchParams struct {
 	offset         int
 	limit          int
 	ignoreMessages bool
+	adminPhone     string
 }
 
 // retrieves users by for given period and write them to file in ./ban directory
@@ -53,7 +55,7 @@ func searchAndStoreUsersToBan(ctx context.Context, api *tg.Client, channel *tg.C
 		log.Printf("[INFO] No users to ban found")
 		return
 	}
-	if err := writeUsersToFile(usersToBan, fileName); err != nil {
+	if err := writeUsersToFile(usersToBan, newBanListMeta(channel, params), fileName); err != nil {
 		log.Printf("[ERROR] Error writing users to ban to file: %v", err)
 	} else {
 		log.Printf("[INFO] Success, users to ban written to %s", fileName)
@@ -160,7 +162,7 @@ func getSingleUserStoreInfo(ctx context.Context, api *tg.Client, channel *tg.Cha
 
 	var message string
 	if !ignoreMessages {
-		message = getSingeUserMessage(ctx, api, channel, userToBan.info.AsInputPeer())
+		message, userInfoToStore.messageID = getSingeUserMessage(ctx, api, channel, userToBan.info.AsInputPeer())
 		userInfoToStore.message = message
 		if len([]rune(message)) > 50 {
 			message = string([]rune(message)[:45]) + "... (truncated)"
@@ -176,9 +178,8 @@ func getSingleUserStoreInfo(ctx context.Context, api *tg.Client, channel *tg.Cha
 	return userInfoToStore
 }
 
-// getSingeUserMessage retrieves single user (last?) message from given channel from Telegram API
-func getSingeUserMessage(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass) string {
-	var message string
+// getSingeUserMessage retrieves single user (last?) message and its ID from given channel from Telegram API
+func getSingeUserMessage(ctx context.Context, api *tg.Client, channel *tg.Channel, user tg.InputPeerClass) (message string, id int) {
 	messages, err := api.MessagesSearch(ctx, &tg.MessagesSearchRequest{
 		FromID: user,
 		Peer:   channel.AsInputPeer(),
@@ -187,10 +188,10 @@ func getSingeUserMessage(ctx context.Context, api *tg.Client, channel *tg.Channe
 	})
 	if err != nil {
 		log.Printf("[ERROR] Error retrieving user %s message: %v", user.String(), err)
-		return ""
+		return "", 0
 	}
 	if messages.Zero() {
-		return ""
+		return "", 0
 	}
 	var rawMessages []tg.MessageClass
 	switch v := messages.(type) {
@@ -202,6 +203,7 @@ func getSingeUserMessage(ctx context.Context, api *tg.Client, channel *tg.Channe
 		rawMessages = v.Messages
 	}
 	if len(rawMessages) == 1 {
+		id = rawMessages[0].GetID()
 		switch v := rawMessages[0].(type) {
 		case *tg.Message:
 			message = v.GetMessage()
@@ -213,5 +215,5 @@ func getSingeUserMessage(ctx context.Context, api *tg.Client, channel *tg.Channe
 		}
 	}
 
-	return message
+	return message, id
 }
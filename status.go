@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// banStatus is the state of a single user in the ban run
+type banStatus string
+
+const (
+	banStatusPending banStatus = "pending"
+	banStatusDone    banStatus = "done"
+	banStatusFailed  banStatus = "failed"
+	banStatusSkipped banStatus = "skipped" // already banned, not in the channel or protected
+)
+
+// banStatusEntry is a single record of the status sidecar
+type banStatusEntry struct {
+	userID    int64
+	status    banStatus
+	updated   time.Time
+	class     failureClass
+	deleted   int
+	remaining int
+	reported  string
+	err       string
+}
+
+// banStatusFile is the append-only sidecar of the ban list which records the state of every processed user,
+// so the run could be resumed exactly where it stopped without touching the ban list itself.
+// Later records for the same user override earlier ones.
+type banStatusFile struct {
+	path     string
+	file     *os.File
+	writer   *csv.Writer
+	statuses map[int64]banStatusEntry
+}
+
+// statusFilePath returns path of the status sidecar for given ban list
+func statusFilePath(banListPath string) string {
+	return banListPath + ".status"
+}
+
+// openBanStatus reads existing status sidecar if there is one and opens it for appending new records
+func openBanStatus(path string) (*banStatusFile, error) {
+	s := &banStatusFile{path: path, statuses: map[int64]banStatusEntry{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	_, statErr := os.Stat(path)
+	isNew := errors.Is(statErr, os.ErrNotExist)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening status file %s: %w", path, err)
+	}
+	s.file = f
+	s.writer = csv.NewWriter(f)
+	s.writer.Comma = '\t'
+	if isNew {
+		if err = s.write([]string{"userID", "status", "updated", "class", "deleted", "remaining", "error", "reported"}); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load reads the status records, missing file is not an error
+func (s *banStatusFile) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening status file %s: %w", s.path, err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = '\t'
+	r.FieldsPerRecord = -1
+	var sawFirstRow bool
+	for {
+		record, e := r.Read()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+		if e != nil {
+			return fmt.Errorf("error reading status file %s: %w", s.path, e)
+		}
+		if !sawFirstRow {
+			sawFirstRow = true
+			continue
+		}
+		if len(record) < 3 {
+			continue // incomplete line written right before the crash
+		}
+		id, convErr := strconv.ParseInt(record[0], 10, 64)
+		if convErr != nil {
+			continue
+		}
+		entry := banStatusEntry{userID: id, status: banStatus(record[1])}
+		entry.updated, _ = time.Parse(time.RFC3339, record[2])
+		if len(record) > 3 {
+			entry.class = failureClass(record[3])
+		}
+		if len(record) > 5 {
+			entry.deleted, _ = strconv.Atoi(record[4])
+			entry.remaining, _ = strconv.Atoi(record[5])
+		}
+		if len(record) > 6 {
+			entry.err = record[6]
+		}
+		if len(record) > 7 {
+			entry.reported = record[7]
+		}
+		s.statuses[id] = entry
+	}
+	return nil
+}
+
+// get returns the status of given user, users without records are pending
+func (s *banStatusFile) get(userID int64) banStatus {
+	if entry, ok := s.statuses[userID]; ok {
+		return entry.status
+	}
+	return banStatusPending
+}
+
+// getEntry returns the latest record for given user
+func (s *banStatusFile) getEntry(userID int64) (banStatusEntry, bool) {
+	entry, ok := s.statuses[userID]
+	return entry, ok
+}
+
+// set records the new status of the user and flushes it to disk right away
+func (s *banStatusFile) set(userID int64, status banStatus, result banResult) error {
+	entry := banStatusEntry{
+		userID:    userID,
+		status:    status,
+		updated:   time.Now(),
+		class:     result.class,
+		deleted:   result.deleted,
+		remaining: result.remaining,
+		reported:  result.reported,
+	}
+	if result.err != nil {
+		entry.err = result.err.Error()
+	}
+	if result.skipReason != "" {
+		entry.err = result.skipReason
+	}
+	s.statuses[userID] = entry
+	return s.write([]string{
+		strconv.FormatInt(userID, 10),
+		string(status),
+		entry.updated.Format(time.RFC3339),
+		string(entry.class),
+		strconv.Itoa(entry.deleted),
+		strconv.Itoa(entry.remaining),
+		entry.err,
+		entry.reported,
+	})
+}
+
+// write appends the record to the file and flushes it
+func (s *banStatusFile) write(record []string) error {
+	if err := s.writer.Write(record); err != nil {
+		return fmt.Errorf("error writing status file %s: %w", s.path, err)
+	}
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return fmt.Errorf("error writing status file %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// count returns number of given users in every status
+func (s *banStatusFile) count(userIDs []int64) map[banStatus]int {
+	res := map[banStatus]int{}
+	for _, id := range userIDs {
+		res[s.get(id)]++
+	}
+	return res
+}
+
+// countClasses returns number of given users with every failure class
+func (s *banStatusFile) countClasses(userIDs []int64) map[failureClass]int {
+	res := map[failureClass]int{}
+	for _, id := range userIDs {
+		if entry, ok := s.statuses[id]; ok && entry.class != failureNone {
+			res[entry.class]++
+		}
+	}
+	return res
+}
+
+// Close closes the underlying file
+func (s *banStatusFile) Close() error {
+	s.writer.Flush()
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("error closing status file %s: %w", s.path, err)
+	}
+	return nil
+}
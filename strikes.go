@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// steps of the strike ladder
+const (
+	strikeStepWarn = "warn" // send the warning mentioning the user
+	strikeStepMute = "mute" // forbid the user to send anything for a while
+	strikeStepBan  = "ban"  // ban the user and delete all their messages
+)
+
+// strikeParams are the settings of the strike ladder
+type strikeParams struct {
+	enabled bool
+	steps   []string      // step for every strike count, the last one is repeated for the further strikes
+	mute    time.Duration // duration of the first mute, doubled with every next one
+	decay   time.Duration // one strike is forgiven after that time without violations, 0 never forgives
+	warning string        // text of the warning message
+}
+
+// step returns the ladder step for given strikes count along with the mute duration for the mute step
+func (p strikeParams) step(strikes int) (step string, mute time.Duration) {
+	idx := min(strikes, len(p.steps)) - 1
+	step = p.steps[idx]
+	if step != strikeStepMute {
+		return step, 0
+	}
+	// mutes before this one, counting the repeated last step
+	var mutes int
+	for i := 0; i < idx; i++ {
+		if p.steps[i] == strikeStepMute {
+			mutes++
+		}
+	}
+	mutes += strikes - 1 - idx
+	mute = p.mute
+	for i := 0; i < mutes && mute < maxRestriction; i++ {
+		mute *= 2
+	}
+	return step, min(mute, maxRestriction)
+}
+
+// strikeRecord is the strikes count of a single user
+type strikeRecord struct {
+	UserID  int64     `json:"user_id"`
+	Strikes int       `json:"strikes"`
+	Last    time.Time `json:"last"` // time of the last violation
+}
+
+// strikeStore is the state file of the users strikes in the channel
+type strikeStore struct {
+	path  string
+	decay time.Duration
+	mu    sync.Mutex
+}
+
+// strikesFilePath returns path of the strikes state for given channel
+func strikesFilePath(channelID int64) string {
+	return fmt.Sprintf("./ban/%d.strikes.json", channelID)
+}
+
+// add records the violation of the user and returns their strikes count, forgiving the decayed strikes first
+func (s *strikeStore) add(userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	r := records[userID]
+	r.UserID = userID
+	r.Strikes = s.decayed(r, now)
+	r.Strikes++
+	r.Last = now
+	records[userID] = r
+
+	list := make([]strikeRecord, 0, len(records))
+	for _, rec := range records {
+		if s.decayed(rec, now) > 0 {
+			list = append(list, rec)
+		}
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("error encoding strikes: %w", err)
+	}
+	if err = os.WriteFile(s.path, data, 0o600); err != nil {
+		return 0, fmt.Errorf("error writing strikes %s: %w", s.path, err)
+	}
+	return r.Strikes, nil
+}
+
+// decayed returns strikes count of the record with the strikes forgiven since the last violation
+func (s *strikeStore) decayed(r strikeRecord, now time.Time) int {
+	if s.decay <= 0 || r.Last.IsZero() {
+		return r.Strikes
+	}
+	return max(r.Strikes-int(now.Sub(r.Last)/s.decay), 0)
+}
+
+// load reads the state file, missing file is an empty state
+func (s *strikeStore) load() (map[int64]strikeRecord, error) {
+	records := map[int64]strikeRecord{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading strikes %s: %w", s.path, err)
+	}
+	var list []strikeRecord
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing strikes %s: %w", s.path, err)
+	}
+	for _, r := range list {
+		records[r.UserID] = r
+	}
+	return records, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStrikeParamsStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  strikeParams
+		strikes int
+		step    string
+		mute    time.Duration
+	}{
+		{"first warning", strikeParams{steps: []string{strikeStepWarn, strikeStepMute, strikeStepMute, strikeStepBan}, mute: time.Hour}, 1, strikeStepWarn, 0},
+		{"first mute", strikeParams{steps: []string{strikeStepWarn, strikeStepMute, strikeStepMute, strikeStepBan}, mute: time.Hour}, 2, strikeStepMute, time.Hour},
+		{"second mute is doubled", strikeParams{steps: []string{strikeStepWarn, strikeStepMute, strikeStepMute, strikeStepBan}, mute: time.Hour}, 3, strikeStepMute, 2 * time.Hour},
+		{"ban", strikeParams{steps: []string{strikeStepWarn, strikeStepMute, strikeStepMute, strikeStepBan}, mute: time.Hour}, 4, strikeStepBan, 0},
+		{"last step is repeated", strikeParams{steps: []string{strikeStepWarn, strikeStepMute, strikeStepMute, strikeStepBan}, mute: time.Hour}, 10, strikeStepBan, 0},
+		{"repeated warning", strikeParams{steps: []string{strikeStepWarn}, mute: time.Hour}, 3, strikeStepWarn, 0},
+		{"repeated mute keeps doubling", strikeParams{steps: []string{strikeStepWarn, strikeStepMute}, mute: time.Hour}, 4, strikeStepMute, 4 * time.Hour},
+		{"mute doubling is capped", strikeParams{steps: []string{strikeStepWarn, strikeStepMute}, mute: time.Hour}, 20, strikeStepMute, maxRestriction},
+		{"mute is capped on the first doubling", strikeParams{steps: []string{strikeStepMute}, mute: 200 * 24 * time.Hour}, 2, strikeStepMute, maxRestriction},
+		{"huge strikes count doesn't overflow", strikeParams{steps: []string{strikeStepMute}, mute: time.Minute}, 1000, strikeStepMute, maxRestriction},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, mute := tt.params.step(tt.strikes)
+			if step != tt.step || mute != tt.mute {
+				t.Errorf("step(%d): expected %s for %s, got %s for %s", tt.strikes, tt.step, tt.mute, step, mute)
+			}
+		})
+	}
+}
+
+func TestStrikeStoreDecayed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		decay   time.Duration
+		record  strikeRecord
+		strikes int
+	}{
+		{"no decay", 0, strikeRecord{Strikes: 3, Last: now.Add(-1000 * time.Hour)}, 3},
+		{"no last violation", 24 * time.Hour, strikeRecord{Strikes: 3}, 3},
+		{"not decayed yet", 24 * time.Hour, strikeRecord{Strikes: 3, Last: now.Add(-23 * time.Hour)}, 3},
+		{"one strike per decay", 24 * time.Hour, strikeRecord{Strikes: 3, Last: now.Add(-49 * time.Hour)}, 1},
+		{"not below zero", 24 * time.Hour, strikeRecord{Strikes: 3, Last: now.Add(-100 * time.Hour)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &strikeStore{decay: tt.decay}
+			if got := s.decayed(tt.record, now); got != tt.strikes {
+				t.Errorf("expected %d strikes, got %d", tt.strikes, got)
+			}
+		})
+	}
+}
+
+func TestStrikeStoreAdd(t *testing.T) {
+	s := &strikeStore{path: filepath.Join(t.TempDir(), "strikes.json"), decay: time.Hour}
+	for i, expected := range []int{1, 2, 3} {
+		strikes, err := s.add(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strikes != expected {
+			t.Errorf("strike %d: expected %d strikes, got %d", i, expected, strikes)
+		}
+	}
+	if strikes, err := s.add(2); err != nil || strikes != 1 {
+		t.Errorf("expected the first strike of another user, got %d: %v", strikes, err)
+	}
+	// state is read from the file, so the restarted watch keeps the strikes
+	restarted := &strikeStore{path: s.path, decay: time.Hour}
+	if strikes, err := restarted.add(1); err != nil || strikes != 4 {
+		t.Errorf("expected 4 strikes after the restart, got %d: %v", strikes, err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/tg"
+)
+
+// verifyMismatch is the processed user whose actual state differs from the expected one
+type verifyMismatch struct {
+	userID    int64
+	status    banStatus
+	expected  string
+	actual    string
+	remaining int
+	problem   string
+}
+
+// verifyBans checks that every processed user of the ban list has the state expected after the action
+// and no messages in the deletion scope, and writes the mismatches to the reconciliation report next to the ban list
+func verifyBans(ctx context.Context, api *tg.Client, channel *tg.Channel, list banList, status *banStatusFile, params banParams) {
+	expectedRights, checkRights := params.action.rights(params.restrictFor)
+	expected := fmt.Sprintf("%s, no messages", params.action)
+
+	var checked int
+	var mismatches []verifyMismatch
+	for _, entry := range list.entries {
+		userStatus := status.get(entry.user.userID)
+		if userStatus == banStatusPending || userStatus == banStatusSkipped {
+			continue
+		}
+		if ctx.Err() != nil {
+			log.Printf("[INFO] Verification canceled after checking %d users", checked)
+			break
+		}
+		checked++
+		user := params.resolver.inputPeer(entry)
+		m := verifyMismatch{userID: user.UserID, status: userStatus, expected: expected}
+		var problems []string
+
+		if checkRights {
+			info, err := params.resolver.getParticipant(ctx, user)
+			switch {
+			case err != nil:
+				m.actual = "unknown"
+				problems = append(problems, fmt.Sprintf("error retrieving participant: %v", err))
+			case info.state != participantBanned && info.state != participantRestricted:
+				m.actual = string(info.state)
+				problems = append(problems, fmt.Sprintf("user is %s", info.state))
+			case params.action == banActionBan && info.state != participantBanned:
+				m.actual = string(info.state)
+				problems = append(problems, "user is restricted, but not banned")
+			case !rightsCovered(info.rights, expectedRights):
+				m.actual = string(info.state)
+				problems = append(problems, "user has more rights than expected")
+			default:
+				m.actual = string(info.state)
+			}
+		}
+
+		remaining, err := countRemainingMessages(ctx, api, channel, user, params.scope)
+		m.remaining = remaining
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("error counting messages: %v", err))
+		}
+		if remaining > 0 {
+			problems = append(problems, fmt.Sprintf("%d messages remain", remaining))
+		}
+
+		if len(problems) > 0 {
+			m.problem = strings.Join(problems, "; ")
+			log.Printf("[WARN] Verification of the user %d failed: %s", user.UserID, m.problem)
+			mismatches = append(mismatches, m)
+		}
+	}
+
+	log.Printf("[INFO] Verification finished: %d users checked, %d mismatches", checked, len(mismatches))
+	if len(mismatches) == 0 {
+		return
+	}
+	reportPath := strings.TrimSuffix(params.filePath, ".csv") + ".verify.csv"
+	if err := writeVerifyReport(mismatches, reportPath); err != nil {
+		log.Printf("[ERROR] Error writing verification report: %v", err)
+		return
+	}
+	log.Printf("[INFO] Verification mismatches are written to %s", reportPath)
+}
+
+// writeVerifyReport writes verification mismatches to tab-separated csv file
+func writeVerifyReport(mismatches []verifyMismatch, fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %w", fileName, err)
+	}
+	defer func() {
+		if e := file.Close(); e != nil {
+			log.Printf("[ERROR] Error closing file %s: %v", fileName, e)
+		}
+	}()
+
+	writer := csv.NewWriter(file)
+	writer.Comma = '\t'
+	if err = writer.Write([]string{"userID", "status", "expected", "actual", "remaining", "problem"}); err != nil {
+		return fmt.Errorf("error writing row to csv: %w", err)
+	}
+	for _, m := range mismatches {
+		err = writer.Write([]string{
+			strconv.FormatInt(m.userID, 10),
+			string(m.status),
+			m.expected,
+			m.actual,
+			strconv.Itoa(m.remaining),
+			m.problem,
+		})
+		if err != nil {
+			return fmt.Errorf("error writing row to csv: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+)
+
+// candidates are written to the file and join rate is logged that often
+const watchFlushInterval = time.Minute
+
+// messages waiting for the checks and responses making API calls, more are not checked
+const moderationQueueSize = 1000
+
+// watchParams are the settings of the watch mode
+type watchParams struct {
+	window       time.Duration  // recent joiners are tracked for that long
+	burstJoins   int            // that many joins within burstWindow is a join burst, 0 disables burst detection
+	burstWindow  time.Duration  // window for the join burst detection
+	match        *regexp.Regexp // messages of recent joiners matching it make them candidates, nil disables the check
+	profileMatch *regexp.Regexp // joiners with name or username matching it are candidates, nil disables the check
+	quarantine   time.Duration  // new joiners are forbidden to send media and links for that long, 0 disables the quarantine
+	inspect      inspectParams  // inspection of the first messages of the new members
+	flood        floodParams    // per-user message flood detection
+	strikes      strikeParams   // strike ladder replacing the immediate response to the rule violations
+	adminPhone   string
+
+	// automatic response to the triggers
+	action     banAction     // none only writes the candidates
+	maxActions int           // maximum number of users acted upon within the cooldown, 0 is no limit
+	cooldown   time.Duration // window for maxActions
+	ban        banParams     // parameters of the messages deletion and the action
+}
+
+// watcher tracks the recent joiners of the channel from the updates and collects ban candidates among them
+type watcher struct {
+	api        *tg.Client
+	channel    *tg.Channel
+	params     watchParams
+	journal    *journal
+	responder  *raidResponder        // nil if the triggers are not acted upon
+	quarantine *quarantiner          // nil if the new joiners are not quarantined
+	inspector  *inspector            // nil if the first messages of the new members are not inspected
+	flood      *floodDetector        // nil if the message flood is not detected
+	strikes    *strikeStore          // nil if the strike ladder is not used
+	moderation chan moderatedMessage // messages checked and responded to in the background
+	moderated  chan struct{}         // closed when the moderation of the messages stops
+
+	mu         sync.Mutex
+	joiners    []banUserInfo           // recent joiners ordered by the join time, not older than the window
+	candidates []banUserInfo           // candidates not written to the file yet
+	flagged    map[int64]time.Time     // users which became candidates already, with the time they became one
+	joins      int                     // joins since the last flush
+	members    map[int64]watchedMember // users seen joining or writing to the channel within the window
+}
+
+// moderatedMessage is the message of the channel member waiting for the checks and responses making API calls,
+// which are done in the background, so the flood wait doesn't stall the processing of the updates
+type moderatedMessage struct {
+	sender banUserInfo
+	msg    *tg.Message
+	flood  *flood // flood detected with the message, nil if none
+}
+
+// watchedMember is the user seen joining or writing to the channel, kept to check their profile changes
+type watchedMember struct {
+	accessHash int64
+	seen       time.Time
+}
+
+// watchChannel receives the updates of the channel until the context is canceled,
+// and writes users from the join bursts and users matching the rules to the ban candidate files
+func watchChannel(ctx context.Context, client *telegram.Client, gaps *updates.Manager, dispatcher tg.UpdateDispatcher, channel *tg.Channel, params watchParams) error {
+	self, err := client.Self(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving the logged-in user: %w", err)
+	}
+	j, err := openJournal(journalFilePath(channel.ID))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := j.Close(); e != nil {
+			log.Printf("[ERROR] %v", e)
+		}
+	}()
+	w := newWatcher(client.API(), channel, params, j)
+	// updates manager could return without the context being canceled, stop the background work in that case as well
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if params.quarantine > 0 {
+		w.quarantine = newQuarantiner(client.API(), channel, params.quarantine, j)
+		go w.quarantine.run(runCtx)
+		log.Printf("[INFO] New joiners are quarantined for %s, state is kept in %s", params.quarantine, w.quarantine.state.path)
+	}
+	if params.action != banActionNone || params.inspect.messages > 0 || params.flood.enabled() {
+		if w.responder, err = newWatchResponder(client.API(), channel, params, j); err != nil {
+			return err
+		}
+		go w.responder.run(runCtx)
+		log.Printf("[INFO] Triggers are acted upon with %q action, at most %d users within %s", params.action, params.maxActions, params.cooldown)
+	}
+	if params.inspect.messages > 0 {
+		w.inspector = newInspector(client.API(), channel, params.inspect)
+		log.Printf("[INFO] First %d messages of the members who joined within %s are inspected for %v",
+			params.inspect.messages, params.inspect.window, w.inspector.rules())
+	}
+	if params.flood.enabled() {
+		w.flood = newFloodDetector(params.flood)
+		log.Printf("[INFO] Message flood is detected within %s, responses are %v", params.flood.window, params.flood.steps)
+	}
+	if params.strikes.enabled {
+		w.strikes = &strikeStore{path: strikesFilePath(channel.ID), decay: params.strikes.decay}
+		log.Printf("[INFO] Rule violations are responded with the strike ladder %v, strikes are kept in %s", params.strikes.steps, w.strikes.path)
+	}
+	if w.inspector != nil || w.flood != nil {
+		go w.moderate(runCtx)
+	} else {
+		close(w.moderated)
+	}
+	w.register(dispatcher)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(watchFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+				w.flush()
+			}
+		}
+	}()
+
+	err = gaps.Run(runCtx, client.API(), self.ID, updates.AuthOptions{
+		OnStart: func(context.Context) {
+			log.Printf("[INFO] Watching channel %q for new joiners and messages, press Ctrl+C to stop", channel.Title)
+		},
+	})
+	cancel()
+	<-done
+	w.flush()
+	<-w.moderated
+	if w.quarantine != nil {
+		w.quarantine.wait()
+	}
+	if w.responder != nil {
+		w.responder.wait()
+		w.responder.params.resolver.flush()
+	}
+	if w.responder != nil && w.responder.params.archive != nil {
+		if sum, e := w.responder.params.archive.writeManifest(); e != nil {
+			log.Printf("[ERROR] %v", e)
+		} else {
+			log.Printf("[INFO] Evidence archive is written to %s, manifest sha256 is %s", w.responder.params.archive.dir, sum)
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// newWatcher creates watcher of the channel
+func newWatcher(api *tg.Client, channel *tg.Channel, params watchParams, j *journal) *watcher {
+	return &watcher{
+		api:        api,
+		channel:    channel,
+		params:     params,
+		journal:    j,
+		moderation: make(chan moderatedMessage, moderationQueueSize),
+		moderated:  make(chan struct{}),
+		flagged:    map[int64]time.Time{},
+		members:    map[int64]watchedMember{},
+	}
+}
+
+// newWatchResponder creates responder acting upon the triggered users
+func newWatchResponder(api *tg.Client, channel *tg.Channel, params watchParams, j *journal) (*raidResponder, error) {
+	ban := params.ban
+	var err error
+	if ban.resolver, err = newPeerResolver(api, channel, nil, ban.peerCachePath); err != nil {
+		return nil, err
+	}
+	if ban.archiveDir != "" {
+		if ban.archive, err = newEvidenceArchive(ban.archiveDir, ban.archiveMedia); err != nil {
+			return nil, err
+		}
+	}
+	return newRaidResponder(api, channel, ban, params.maxActions, params.cooldown, j), nil
+}
+
+// register subscribes the watcher to the updates it handles
+func (w *watcher) register(d tg.UpdateDispatcher) {
+	d.OnNewChannelMessage(w.onNewChannelMessage)
+	d.OnChannelParticipant(w.onChannelParticipant)
+	d.OnUserName(w.onUserName)
+}
+
+// onNewChannelMessage handles join service messages and messages of the users in the channel
+func (w *watcher) onNewChannelMessage(_ context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
+	switch msg := u.Message.(type) {
+	case *tg.MessageService:
+		if !w.inChannel(msg.PeerID) {
+			return nil
+		}
+		date := time.Unix(int64(msg.Date), 0)
+		for _, userID := range joinedUsers(msg) {
+			if user, ok := e.Users[userID]; ok && w.join(user, date) && !addedByOther(msg, userID) {
+				w.quarantineJoiner(user)
+			}
+		}
+	case *tg.Message:
+		if !w.inChannel(msg.PeerID) {
+			return nil
+		}
+		from, ok := msg.GetFromID()
+		if !ok {
+			return nil
+		}
+		if peer, ok := from.(*tg.PeerUser); ok {
+			if user, ok := e.Users[peer.UserID]; ok {
+				w.remember(user)
+			}
+			w.message(peer.UserID, msg)
+			m := moderatedMessage{sender: senderInfo(e, peer.UserID, msg), msg: msg}
+			if w.flood != nil {
+				// flood detection is in memory and is done right away, only the response is queued
+				if f, ok := w.flood.add(peer.UserID, msg); ok {
+					m.flood = &f
+				}
+			}
+			if w.inspector != nil || m.flood != nil {
+				w.enqueueModeration(m)
+			}
+		}
+	}
+	return nil
+}
+
+// onChannelParticipant handles users joining the channel without the service message
+func (w *watcher) onChannelParticipant(_ context.Context, e tg.Entities, u *tg.UpdateChannelParticipant) error {
+	if u.ChannelID != w.channel.ID || u.PrevParticipant != nil {
+		return nil
+	}
+	if _, ok := u.NewParticipant.(*tg.ChannelParticipant); !ok {
+		return nil
+	}
+	// users added by someone else, like the admin, are not quarantined,
+	// while the ones joined by the invite link have it set even if the admin approved their request
+	added := u.ActorID != u.UserID && u.Invite == nil
+	if user, ok := e.Users[u.UserID]; ok && w.join(user, time.Unix(int64(u.Date), 0)) && !added {
+		w.quarantineJoiner(user)
+	}
+	return nil
+}
+
+// onUserName checks the changed name and username of the channel member against the profile rule
+func (w *watcher) onUserName(ctx context.Context, e tg.Entities, u *tg.UpdateUserName) error {
+	if w.params.profileMatch == nil {
+		return nil
+	}
+	w.mu.Lock()
+	member, ok := w.members[u.UserID]
+	w.mu.Unlock()
+	accessHash := member.accessHash
+	if user, found := e.Users[u.UserID]; found {
+		accessHash, ok = user.AccessHash, true
+	}
+	if !ok {
+		log.Printf("[DEBUG] User %d changed their name to %q, but their access hash is unknown", u.UserID, u.FirstName+" "+u.LastName)
+		return nil
+	}
+	info := banUserInfo{userID: u.UserID, accessHash: accessHash, firstName: u.FirstName, lastName: u.LastName}
+	for _, username := range u.Usernames {
+		if username.Active {
+			info.username = username.Username
+			break
+		}
+	}
+	if !w.params.profileMatch.MatchString(profileString(info)) {
+		return nil
+	}
+	// name updates come for all the users known to the account, not only for the channel members
+	participant, err := getParticipant(ctx, w.api, w.channel, &tg.InputPeerUser{UserID: u.UserID, AccessHash: accessHash})
+	if err != nil {
+		log.Printf("[WARN] User %d changed their profile to %q matching the rule, but their state can't be retrieved: %v", u.UserID, profileString(info), err)
+		return nil
+	}
+	if participant.state != participantMember && participant.state != participantRestricted {
+		return nil
+	}
+	info.joined = participant.joined
+	log.Printf("[WARN] Channel member %d changed their profile to %q matching the rule, new ban candidate", u.UserID, profileString(info))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.flag(info) {
+		w.trigger("profile change", []banUserInfo{info})
+	}
+	return nil
+}
+
+// remember records the access hash of the channel member to check their profile changes later
+func (w *watcher) remember(user *tg.User) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.members[user.ID] = watchedMember{accessHash: user.AccessHash, seen: time.Now()}
+}
+
+// inChannel checks that the message is sent to the watched channel
+func (w *watcher) inChannel(peer tg.PeerClass) bool {
+	p, ok := peer.(*tg.PeerChannel)
+	return ok && p.ChannelID == w.channel.ID
+}
+
+// joinedUsers returns IDs of the users who joined the channel according to the service message
+func joinedUsers(msg *tg.MessageService) []int64 {
+	switch action := msg.Action.(type) {
+	case *tg.MessageActionChatAddUser:
+		return action.Users
+	case *tg.MessageActionChatJoinedByLink, *tg.MessageActionChatJoinedByRequest:
+		if from, ok := msg.GetFromID(); ok {
+			if peer, ok := from.(*tg.PeerUser); ok {
+				return []int64{peer.UserID}
+			}
+		}
+	}
+	return nil
+}
+
+// addedByOther returns true if the user was added to the channel by someone else, like the admin,
+// and didn't join on their own
+func addedByOther(msg *tg.MessageService, userID int64) bool {
+	if _, ok := msg.Action.(*tg.MessageActionChatAddUser); !ok {
+		return false
+	}
+	from, ok := msg.GetFromID()
+	if !ok {
+		return false
+	}
+	peer, ok := from.(*tg.PeerUser)
+	return !ok || peer.UserID != userID
+}
+
+// join records the new joiner, and marks all joiners of the burst as candidates if it's detected.
+// Returns false if the join is recorded already.
+func (w *watcher) join(user *tg.User, date time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.prune(date)
+	if w.findJoiner(user.ID) >= 0 {
+		return false // both service message and participant update are received for the same join
+	}
+	info := userInfoFromUser(user)
+	info.joined = date
+	w.joiners = append(w.joiners, info)
+	w.members[user.ID] = watchedMember{accessHash: user.AccessHash, seen: time.Now()}
+	w.joins++
+	if w.inspector != nil {
+		w.inspector.joined(user.ID, date)
+	}
+	log.Printf("[DEBUG] User %d (%s %s) joined", user.ID, user.FirstName, user.LastName)
+
+	if w.params.profileMatch != nil && w.params.profileMatch.MatchString(profileString(info)) && w.flag(info) {
+		log.Printf("[WARN] Profile of the joiner %d matches the rule, new ban candidate", user.ID)
+		w.trigger("profile rule", []banUserInfo{info})
+	}
+	if w.params.burstJoins <= 0 {
+		return true
+	}
+	var burst []banUserInfo
+	for _, j := range w.joiners {
+		if date.Sub(j.joined) <= w.params.burstWindow {
+			burst = append(burst, j)
+		}
+	}
+	if len(burst) < w.params.burstJoins {
+		return true
+	}
+	var added []banUserInfo
+	for _, j := range burst {
+		if w.flag(j) {
+			added = append(added, j)
+		}
+	}
+	if len(added) > 0 {
+		log.Printf("[WARN] Join burst: %d joins within %s, %d new ban candidates", len(burst), w.params.burstWindow, len(added))
+		w.trigger("join burst", added)
+	}
+	return true
+}
+
+// quarantineJoiner queues the quarantine of the new joiner if the quarantine is enabled.
+// Candidates acted upon automatically are not quarantined, as the quarantine would replace their ban.
+func (w *watcher) quarantineJoiner(user *tg.User) {
+	if w.quarantine == nil {
+		return
+	}
+	w.mu.Lock()
+	_, flagged := w.flagged[user.ID]
+	w.mu.Unlock()
+	if flagged && w.params.action != banActionNone {
+		log.Printf("[INFO] User %d is a ban candidate acted upon, not quarantining", user.ID)
+		return
+	}
+	w.quarantine.enqueue(user)
+}
+
+// message checks the message of the user against the rules if the user is a recent joiner
+func (w *watcher) message(userID int64, msg *tg.Message) {
+	if w.params.match == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	i := w.findJoiner(userID)
+	if i < 0 || !w.params.match.MatchString(msg.Message) {
+		return
+	}
+	j := &w.joiners[i]
+	if j.messageID == 0 {
+		j.message, j.messageID = msg.Message, msg.ID
+	}
+	if w.flag(*j) {
+		log.Printf("[WARN] Message %d of the recent joiner %d matches the rule, new ban candidate", msg.ID, userID)
+		w.trigger("message rule", []banUserInfo{*j})
+	}
+}
+
+// trigger acts upon the users if the automatic response is enabled, and journals them otherwise
+func (w *watcher) trigger(reason string, users []banUserInfo) {
+	if w.params.action != banActionNone {
+		w.responder.enqueue(raidTrigger{reason: reason, action: w.params.action, users: users})
+		return
+	}
+	for _, u := range users {
+		w.journal.record(journalEntry{trigger: reason, user: u, action: string(banActionNone), result: "ban candidate"})
+	}
+}
+
+// enqueueModeration schedules the checks of the message without blocking the updates processing
+func (w *watcher) enqueueModeration(m moderatedMessage) {
+	select {
+	case w.moderation <- m:
+	default:
+		log.Printf("[ERROR] Moderation queue is full, message %d of the user %d is not checked", m.msg.ID, m.sender.userID)
+		if m.flood != nil {
+			w.journal.record(journalEntry{trigger: "flood: " + m.flood.reason, user: m.sender, action: m.flood.step, result: "dropped: queue is full"})
+		}
+	}
+}
+
+// moderate checks the queued messages and responds to the violations until the context is canceled
+func (w *watcher) moderate(ctx context.Context) {
+	defer close(w.moderated)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-w.moderation:
+			w.inspectMessage(ctx, m.sender, m.msg)
+			if m.flood != nil {
+				w.respondFlood(ctx, m.sender, *m.flood)
+			}
+		}
+	}
+}
+
+// inspectMessage checks the message against the content rules if it's one of the first messages of the new member.
+// On a match, the message is deleted right away and the user is banned with their messages deleted.
+func (w *watcher) inspectMessage(ctx context.Context, info banUserInfo, msg *tg.Message) {
+	if w.inspector == nil {
+		return
+	}
+	userID := info.userID
+	rule := w.inspector.inspect(ctx, userID, msg)
+	if rule == "" {
+		return
+	}
+	reason := "first message rule: " + rule
+	if w.strikes != nil {
+		log.Printf("[WARN] Message %d of the new member %d matches %s rule", msg.ID, userID, rule)
+		w.strike(ctx, reason, info, []int{msg.ID})
+		return
+	}
+	log.Printf("[WARN] Message %d of the new member %d matches %s rule, deleting it and banning the user", msg.ID, userID, rule)
+
+	w.deleteMessages(ctx, reason, info, []int{msg.ID})
+
+	w.mu.Lock()
+	w.flag(info)
+	w.mu.Unlock()
+	if info.accessHash == 0 {
+		log.Printf("[ERROR] User %d is not found in the update, can't ban them", userID)
+		return
+	}
+	w.responder.enqueue(raidTrigger{reason: reason, action: banActionBan, users: []banUserInfo{info}})
+}
+
+// respondFlood responds to the flood detected for the user with the next step of the escalation
+func (w *watcher) respondFlood(ctx context.Context, info banUserInfo, f flood) {
+	userID := info.userID
+	reason := "flood: " + f.reason
+	if info.accessHash == 0 {
+		log.Printf("[ERROR] Flood by the user %d: %s, but the user is not found in the update, can't respond", userID, f.reason)
+		return
+	}
+	user := &tg.InputPeerUser{UserID: info.userID, AccessHash: info.accessHash}
+	participant, err := getParticipant(ctx, w.api, w.channel, user)
+	if err != nil {
+		log.Printf("[ERROR] Flood by the user %d: %s, but their state can't be retrieved: %v", userID, f.reason, err)
+		return
+	}
+	if skip := skipReason(participant, 0); skip != "" {
+		log.Printf("[INFO] Flood by the user %d: %s, not responding: %s", userID, f.reason, skip)
+		return
+	}
+	if w.strikes != nil {
+		log.Printf("[WARN] Flood by the user %d: %s", userID, f.reason)
+		w.strike(ctx, reason, info, f.messages)
+		return
+	}
+	log.Printf("[WARN] Flood by the user %d: %s, responding with %s", userID, f.reason, f.step)
+
+	switch f.step {
+	case floodStepDelete:
+		w.deleteMessages(ctx, reason, info, f.messages)
+	case floodStepMute:
+		w.deleteMessages(ctx, reason, info, f.messages)
+		w.restrict(ctx, reason, info, w.params.flood.mute)
+	case floodStepBan:
+		w.responder.enqueue(raidTrigger{reason: reason, action: banActionBan, users: []banUserInfo{info}})
+	}
+}
+
+// strike adds the strike to the user for the violation, and responds with the ladder step for their strikes count.
+// The violating messages are deleted at every step, before the warning mentioning the user is sent.
+// It makes API calls, so it's called from the moderation goroutine only and never from the updates handlers.
+func (w *watcher) strike(ctx context.Context, reason string, user banUserInfo, messages []int) {
+	strikes, err := w.strikes.add(user.userID)
+	if err != nil {
+		log.Printf("[ERROR] Error adding the strike to the user %d: %v", user.userID, err)
+		return
+	}
+	step, mute := w.params.strikes.step(strikes)
+	log.Printf("[INFO] User %d has %d strikes, responding with %s", user.userID, strikes, step)
+	reason = fmt.Sprintf("%s, strike %d", reason, strikes)
+	switch step {
+	case strikeStepWarn:
+		w.deleteMessages(ctx, reason, user, messages)
+		w.warn(ctx, reason, user, strikes)
+	case strikeStepMute:
+		w.deleteMessages(ctx, reason, user, messages)
+		w.restrict(ctx, reason, user, mute)
+	case strikeStepBan:
+		w.responder.enqueue(raidTrigger{reason: reason, action: banActionBan, users: []banUserInfo{user}})
+	}
+}
+
+// warn sends the warning mentioning the user from the admin account and journals the result.
+// The violating message is deleted already, so the warning is not a reply to it.
+func (w *watcher) warn(ctx context.Context, reason string, user banUserInfo, strikes int) {
+	entry := journalEntry{trigger: reason, user: user, action: "warn"}
+	name := strings.TrimSpace(user.firstName + " " + user.lastName)
+	if name == "" {
+		name = fmt.Sprintf("user %d", user.userID)
+	}
+	_, err := w.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		Peer:    w.channel.AsInputPeer(),
+		Message: fmt.Sprintf("%s, %s\n\nStrike %d.", name, w.params.strikes.warning, strikes),
+		Entities: []tg.MessageEntityClass{&tg.InputMessageEntityMentionName{
+			Offset: 0,
+			Length: len(utf16.Encode([]rune(name))), // entity offsets are in UTF-16 code units
+			UserID: &tg.InputUser{UserID: user.userID, AccessHash: user.accessHash},
+		}},
+		RandomID: rand.Int64(),
+	})
+	if err != nil {
+		log.Printf("[ERROR] Error warning the user %d: %v", user.userID, err)
+		entry.result = "failed: " + err.Error()
+	} else {
+		entry.result = "warning sent mentioning the user"
+	}
+	w.journal.record(entry)
+}
+
+// deleteMessages deletes given messages of the user and journals the result
+func (w *watcher) deleteMessages(ctx context.Context, reason string, user banUserInfo, ids []int) {
+	entry := journalEntry{trigger: reason, user: user, action: "delete messages"}
+	if _, err := w.api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{Channel: w.channel.AsInput(), ID: ids}); err != nil {
+		log.Printf("[ERROR] Error deleting messages %v of the user %d: %v", ids, user.userID, err)
+		entry.result = "failed: " + err.Error()
+	} else {
+		entry.result = fmt.Sprintf("messages %v deleted", ids)
+	}
+	w.journal.record(entry)
+}
+
+// restrict forbids the user to send anything for given duration and journals the result
+func (w *watcher) restrict(ctx context.Context, reason string, user banUserInfo, duration time.Duration) {
+	entry := journalEntry{trigger: reason, user: user, action: fmt.Sprintf("mute for %s", duration)}
+	rights, _ := banActionRestrict.rights(duration)
+	_, err := w.api.ChannelsEditBanned(ctx, &tg.ChannelsEditBannedRequest{
+		Channel:      w.channel.AsInput(),
+		Participant:  &tg.InputPeerUser{UserID: user.userID, AccessHash: user.accessHash},
+		BannedRights: rights,
+	})
+	if err != nil {
+		log.Printf("[ERROR] Error muting the user %d: %v", user.userID, err)
+		entry.result = "failed: " + err.Error()
+	} else {
+		log.Printf("[INFO] User %d is muted for %s", user.userID, duration)
+		entry.result = "muted until " + time.Unix(int64(rights.UntilDate), 0).Format(time.RFC3339)
+	}
+	w.journal.record(entry)
+}
+
+// senderInfo returns information about the sender of the message, from the update entities if they are there
+func senderInfo(e tg.Entities, userID int64, msg *tg.Message) banUserInfo {
+	info := banUserInfo{userID: userID}
+	if user, ok := e.Users[userID]; ok {
+		info = userInfoFromUser(user)
+	}
+	info.message, info.messageID = msg.Message, msg.ID
+	return info
+}
+
+// profileString returns the name and the username of the user to match the profile rules against
+func profileString(user banUserInfo) string {
+	s := strings.TrimSpace(user.firstName + " " + user.lastName)
+	if user.username != "" {
+		s += " @" + user.username
+	}
+	return s
+}
+
+// flag adds the user to the candidates, returns false if the user is a candidate already
+func (w *watcher) flag(user banUserInfo) bool {
+	if _, ok := w.flagged[user.userID]; ok {
+		return false
+	}
+	w.flagged[user.userID] = time.Now()
+	w.candidates = append(w.candidates, user)
+	return true
+}
+
+// findJoiner returns the index of the user among the recent joiners, or -1 if the user is not there
+func (w *watcher) findJoiner(userID int64) int {
+	for i, j := range w.joiners {
+		if j.userID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+// prune forgets joiners older than the window
+func (w *watcher) prune(now time.Time) {
+	i := 0
+	for i < len(w.joiners) && now.Sub(w.joiners[i].joined) > w.params.window {
+		i++
+	}
+	w.joiners = w.joiners[i:]
+}
+
+// pruneUsers forgets candidates flagged and members last seen earlier than the window ago,
+// so the state of the long-running watch doesn't grow without limit
+func (w *watcher) pruneUsers(now time.Time) {
+	for id, flagged := range w.flagged {
+		if now.Sub(flagged) > w.params.window {
+			delete(w.flagged, id)
+		}
+	}
+	for id, m := range w.members {
+		if now.Sub(m.seen) > w.params.window {
+			delete(w.members, id)
+		}
+	}
+}
+
+// flush logs the join rate and writes the collected candidates to the new ban list file
+func (w *watcher) flush() {
+	if w.flood != nil {
+		w.flood.prune(time.Now())
+	}
+	if w.inspector != nil {
+		w.inspector.prune(time.Now())
+	}
+	w.mu.Lock()
+	w.prune(time.Now())
+	w.pruneUsers(time.Now())
+	candidates, joins, tracked := w.candidates, w.joins, len(w.joiners)
+	w.candidates, w.joins = nil, 0
+	w.mu.Unlock()
+
+	log.Printf("[INFO] %d joins in the last %s, %d recent joiners tracked", joins, watchFlushInterval, tracked)
+	if len(candidates) == 0 {
+		return
+	}
+	meta := banListMeta{
+		channelID:      w.channel.ID,
+		channelTitle:   w.channel.Title,
+		searchFrom:     candidates[0].joined,
+		searchTo:       candidates[0].joined,
+		created:        time.Now(),
+		revision:       revision,
+		adminPhoneHash: phoneHash(w.params.adminPhone),
+	}
+	for _, c := range candidates {
+		if c.joined.Before(meta.searchFrom) {
+			meta.searchFrom = c.joined
+		}
+		if c.joined.After(meta.searchTo) {
+			meta.searchTo = c.joined
+		}
+	}
+	fileName := fmt.Sprintf("./ban/%s.watch.users.csv", time.Now().Format("2006-01-02T15-04-05"))
+	if err := writeUsersToFile(candidates, meta, fileName); err != nil {
+		log.Printf("[ERROR] Error writing ban candidates: %v", err)
+		return
+	}
+	log.Printf("[INFO] %d ban candidates are written to %s, review them and use it with --ban-and-kick-filepath", len(candidates), fileName)
+}